@@ -0,0 +1,186 @@
+package tfcli
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-version"
+)
+
+// lastUsedFileName is the marker file whose modification time records the last use of a cached release
+const lastUsedFileName = ".last-used"
+
+// CachedVersion is a terraform release in the cache directory
+type CachedVersion struct {
+	Version string
+	GOOS    string
+	GOARCH  string
+	// Path is the path to the terraform binary
+	Path string
+	// Size is the size of the release directory in bytes
+	Size int64
+	// LastUsed is the last time the release was returned by Download
+	LastUsed time.Time
+
+	dir string
+}
+
+// ListCachedVersions returns the releases of all platforms in the cache directory sorted by version
+func (d *downloader) ListCachedVersions() ([]CachedVersion, error) {
+	cacheDir, err := d.resolvedCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ioutil.ReadDir(cacheDir)
+	if os.IsNotExist(err) {
+		return []CachedVersion{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cached := []CachedVersion{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		c, ok := readCachedVersion(cacheDir, entry)
+		if !ok {
+			continue
+		}
+		cached = append(cached, c)
+	}
+	sort.SliceStable(cached, func(i, j int) bool {
+		vi := version.Must(version.NewVersion(cached[i].Version))
+		vj := version.Must(version.NewVersion(cached[j].Version))
+		if !vi.Equal(vj) {
+			return vi.LessThan(vj)
+		}
+		return cached[i].GOOS+"_"+cached[i].GOARCH < cached[j].GOOS+"_"+cached[j].GOARCH
+	})
+	return cached, nil
+}
+
+// readCachedVersion reads a release directory named '<version>_<os>_<arch>'.
+// Directories named '<version>' were written by older versions without platform,
+// they are listed with an empty platform so that they can be pruned.
+func readCachedVersion(cacheDir string, entry os.FileInfo) (CachedVersion, bool) {
+	c := CachedVersion{
+		Version: entry.Name(),
+		dir:     filepath.Join(cacheDir, entry.Name()),
+	}
+	if parts := strings.Split(entry.Name(), "_"); len(parts) == 3 {
+		c.Version, c.GOOS, c.GOARCH = parts[0], parts[1], parts[2]
+	}
+	if !isExactVersion(c.Version) {
+		return c, false
+	}
+	c.Path = filepath.Join(c.dir, "terraform")
+	if c.GOOS == "windows" || (c.GOOS == "" && !fileExists(c.Path)) {
+		c.Path += ".exe"
+	}
+	if !fileExists(c.Path) {
+		return c, false
+	}
+	c.LastUsed = entry.ModTime()
+	if info, err := os.Stat(filepath.Join(c.dir, lastUsedFileName)); err == nil {
+		c.LastUsed = info.ModTime()
+	}
+	c.Size = dirSize(c.dir)
+	return c, true
+}
+
+// CacheSize returns the size of all cached releases in bytes
+func (d *downloader) CacheSize() (int64, error) {
+	cached, err := d.ListCachedVersions()
+	if err != nil {
+		return 0, err
+	}
+	size := int64(0)
+	for _, c := range cached {
+		size += c.Size
+	}
+	return size, nil
+}
+
+// RemoveCachedVersion removes the release of the downloader's platform from the cache
+func (d *downloader) RemoveCachedVersion(version string) error {
+	dir, err := d.versionDir(version)
+	if err != nil {
+		return err
+	}
+	return removeCachedDir(dir)
+}
+
+// PruneCache removes the cached releases of all platforms which have not been used for longer than olderThan,
+// but always keeps the keep most recently used releases. A zero olderThan considers all releases.
+// It returns the removed releases.
+func (d *downloader) PruneCache(keep int, olderThan time.Duration) ([]CachedVersion, error) {
+	cached, err := d.ListCachedVersions()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(cached, func(i, j int) bool {
+		return cached[i].LastUsed.After(cached[j].LastUsed)
+	})
+	removed := []CachedVersion{}
+	for i, c := range cached {
+		if i < keep || time.Since(c.LastUsed) < olderThan {
+			continue
+		}
+		err = removeCachedDir(c.dir)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, c)
+	}
+	return removed, nil
+}
+
+// removeCachedDir removes a release directory and its lock file while holding the download lock
+func removeCachedDir(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+	unlock, err := lockFile(context.Background(), dir+".lock")
+	if err != nil {
+		return fmt.Errorf("cannot lock '%s': %w", dir, err)
+	}
+	defer unlock()
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+	// waiting processes notice the removal and lock a new file, see lockFile.
+	// Open files cannot be removed on windows, the lock file may be left behind there.
+	os.Remove(dir + ".lock")
+	return nil
+}
+
+// touchLastUsed records the current time as last use of the release directory
+func touchLastUsed(dir string) error {
+	marker := filepath.Join(dir, lastUsedFileName)
+	now := time.Now()
+	err := os.Chtimes(marker, now, now)
+	if os.IsNotExist(err) {
+		return ioutil.WriteFile(marker, []byte{}, 0644)
+	}
+	return err
+}
+
+// dirSize returns the size of all files in the directory
+func dirSize(dir string) int64 {
+	size := int64(0)
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
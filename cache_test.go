@@ -0,0 +1,137 @@
+package tfcli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setLastUsed moves the last use of the cached release into the past
+func setLastUsed(t *testing.T, d Downloader, dirName string, age time.Duration) {
+	when := time.Now().Add(-age)
+	must(t, os.Chtimes(filepath.Join(d.CacheDir(), dirName, lastUsedFileName), when, when))
+}
+
+func TestListCachedVersions(t *testing.T) {
+	release := newTestRelease(t, "1.1.6", "1.5.7")
+	d := testDownloader(t, release)
+	cached, err := d.ListCachedVersions()
+	assert.NoError(t, err)
+	assert.Empty(t, cached)
+
+	for _, v := range []string{"1.5.7", "1.1.6"} {
+		_, err := d.Download(v, false)
+		must(t, err)
+	}
+	_, err = testDownloader(t, release).SetCacheDir(d.CacheDir()).SetPlatform("windows", "arm64").Download("1.1.6", false)
+	must(t, err)
+
+	cached, err = d.ListCachedVersions()
+	if !assert.NoError(t, err) || !assert.Len(t, cached, 3) {
+		assert.FailNow(t, "unexpected cache content")
+	}
+	assert.Equal(t, "1.1.6", cached[0].Version)
+	assert.Equal(t, "1.5.7", cached[2].Version)
+	if runtime.GOOS+"_"+runtime.GOARCH > "windows_arm64" {
+		cached[0], cached[1] = cached[1], cached[0]
+	}
+	assert.Equal(t, runtime.GOOS, cached[0].GOOS)
+	assert.Equal(t, "windows", cached[1].GOOS)
+	assert.Equal(t, "arm64", cached[1].GOARCH)
+	assert.Equal(t, "terraform.exe", filepath.Base(cached[1].Path))
+	assert.FileExists(t, cached[0].Path)
+	assert.WithinDuration(t, time.Now(), cached[0].LastUsed, time.Minute)
+
+	size, err := d.CacheSize()
+	assert.NoError(t, err)
+	assert.Equal(t, cached[0].Size+cached[1].Size+cached[2].Size, size)
+	assert.Greater(t, cached[0].Size, int64(0))
+}
+
+func TestDownloadRecordsLastUsed(t *testing.T) {
+	release := newTestRelease(t, "1.1.6")
+	d := testDownloader(t, release)
+	_, err := d.Download("1.1.6", false)
+	must(t, err)
+	setLastUsed(t, d, cachedDirName("1.1.6"), 48*time.Hour)
+
+	_, err = d.Download("1.1.6", false)
+	must(t, err)
+	cached, err := d.ListCachedVersions()
+	if assert.NoError(t, err) && assert.Len(t, cached, 1) {
+		assert.WithinDuration(t, time.Now(), cached[0].LastUsed, time.Minute)
+	}
+}
+
+func TestRemoveCachedVersion(t *testing.T) {
+	release := newTestRelease(t, "1.1.6")
+	d := testDownloader(t, release)
+	tffile, err := d.Download("1.1.6", false)
+	must(t, err)
+
+	assert.NoError(t, d.RemoveCachedVersion("1.1.6"))
+	assert.NoFileExists(t, tffile)
+	if runtime.GOOS != "windows" {
+		assert.NoFileExists(t, filepath.Join(d.CacheDir(), cachedDirName("1.1.6")+".lock"))
+	}
+	assert.NoError(t, d.RemoveCachedVersion("1.1.6"))
+	cached, err := d.ListCachedVersions()
+	assert.NoError(t, err)
+	assert.Empty(t, cached)
+}
+
+func TestListCachedVersionsLegacy(t *testing.T) {
+	d := NewDownloader().SetCacheDir(t.TempDir())
+	// releases cached without platform by older versions
+	dir := filepath.Join(d.CacheDir(), "1.1.6")
+	must(t, os.MkdirAll(dir, 0755))
+	must(t, ioutil.WriteFile(filepath.Join(dir, "terraform"), []byte{}, 0755))
+
+	cached, err := d.ListCachedVersions()
+	if assert.NoError(t, err) && assert.Len(t, cached, 1) {
+		assert.Equal(t, "1.1.6", cached[0].Version)
+		assert.Empty(t, cached[0].GOOS)
+		assert.Empty(t, cached[0].GOARCH)
+	}
+	removed, err := d.PruneCache(0, 0)
+	assert.NoError(t, err)
+	assert.Len(t, removed, 1)
+	assert.NoDirExists(t, dir)
+}
+
+func TestPruneCache(t *testing.T) {
+	release := newTestRelease(t, "1.1.6", "1.2.0", "1.3.0", "1.4.0")
+	d := testDownloader(t, release)
+	for i, v := range []string{"1.1.6", "1.2.0", "1.3.0", "1.4.0"} {
+		_, err := d.Download(v, false)
+		must(t, err)
+		setLastUsed(t, d, cachedDirName(v), time.Duration(4-i)*24*time.Hour)
+	}
+
+	// nothing unused for longer than a week
+	removed, err := d.PruneCache(0, 7*24*time.Hour)
+	assert.NoError(t, err)
+	assert.Empty(t, removed)
+
+	// 1.1.6 and 1.2.0 were not used for more than two days
+	removed, err = d.PruneCache(0, 60*time.Hour)
+	if assert.NoError(t, err) && assert.Len(t, removed, 2) {
+		assert.Equal(t, "1.2.0", removed[0].Version)
+		assert.Equal(t, "1.1.6", removed[1].Version)
+	}
+
+	// keep the most recently used release
+	removed, err = d.PruneCache(1, 0)
+	if assert.NoError(t, err) && assert.Len(t, removed, 1) {
+		assert.Equal(t, "1.3.0", removed[0].Version)
+	}
+	cached, err := d.ListCachedVersions()
+	if assert.NoError(t, err) && assert.Len(t, cached, 1) {
+		assert.Equal(t, "1.4.0", cached[0].Version)
+	}
+}
@@ -2,43 +2,146 @@ package tfcli
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/hashicorp/go-version"
 	"github.com/sirupsen/logrus"
 )
 
+// PlanResult is the result of a plan
+type PlanResult struct {
+	// HasChanges is true if the plan contains changes
+	HasChanges bool
+}
+
+// varsFileName is the name of the generated variables file for typed variables.
+// The '.tfvars.json' suffix makes terraform read it as JSON.
+const varsFileName = "tfcli.tfvars.json"
+
+// DefaultGracePeriod is the time terraform gets to shut down gracefully
+// after an interrupt before it is killed.
+const DefaultGracePeriod = 30 * time.Second
+
+// killWaitTimeout is the time to wait for the command to finish after it was killed.
+// Processes which left the process group may keep the output pipes open.
+var killWaitTimeout = 10 * time.Second
+
 // Terraform interface
+//
+// The Context methods interrupt terraform when the context is done, see SetGracePeriod.
+// On unix terraform runs in its own process group, so a Ctrl-C in the terminal does not reach it anymore.
+// Interactive programs must cancel the context on an interrupt instead, e.g. with signal.NotifyContext.
 type Terraform interface {
 	Init() error
+	InitContext(ctx context.Context) error
 	Apply() error
+	ApplyContext(ctx context.Context) error
+	ApplyWithOptions(opts RunOptions) error
+	ApplyWithOptionsContext(ctx context.Context, opts RunOptions) error
 	ApplyWithPlan(planFile string) error
+	ApplyWithPlanContext(ctx context.Context, planFile string) error
+	ApplyWithPlanAndOptions(planFile string, opts RunOptions) error
+	ApplyWithPlanAndOptionsContext(ctx context.Context, planFile string, opts RunOptions) error
 	Plan(planFile string) error
+	PlanContext(ctx context.Context, planFile string) error
+	PlanWithResult(planFile string) (*PlanResult, error)
+	PlanWithResultContext(ctx context.Context, planFile string) (*PlanResult, error)
+	PlanWithOptions(planFile string, opts RunOptions) (*PlanResult, error)
+	PlanWithOptionsContext(ctx context.Context, planFile string, opts RunOptions) (*PlanResult, error)
 	Destroy() error
+	DestroyContext(ctx context.Context) error
+	DestroyWithOptions(opts RunOptions) error
+	DestroyWithOptionsContext(ctx context.Context, opts RunOptions) error
 	Output() (map[string]string, error)
+	OutputContext(ctx context.Context) (map[string]string, error)
+	Outputs() (Outputs, error)
+	OutputsContext(ctx context.Context) (Outputs, error)
+	ShowPlan(planFile string) (*Plan, error)
+	ShowPlanContext(ctx context.Context, planFile string) (*Plan, error)
+	ShowState() (*State, error)
+	ShowStateContext(ctx context.Context) (*State, error)
+	StateList(filter ...string) ([]string, error)
+	StateListContext(ctx context.Context, filter ...string) ([]string, error)
+	StateShow(address string) (string, error)
+	StateShowContext(ctx context.Context, address string) (string, error)
+	StatePull() (*StateFile, error)
+	StatePullContext(ctx context.Context) (*StateFile, error)
+	StateMv(src, dst string) error
+	StateMvContext(ctx context.Context, src, dst string) error
+	StateMvAll(moves []StateMove) error
+	StateMvAllContext(ctx context.Context, moves []StateMove) error
+	StateRm(addresses ...string) error
+	StateRmContext(ctx context.Context, addresses ...string) error
+	StatePush(state io.Reader, force bool) error
+	StatePushContext(ctx context.Context, state io.Reader, force bool) error
+	StateReplaceProvider(from, to string) error
+	StateReplaceProviderContext(ctx context.Context, from, to string) error
+	WorkspaceList() ([]string, error)
+	WorkspaceListContext(ctx context.Context) ([]string, error)
+	WorkspaceShow() (string, error)
+	WorkspaceShowContext(ctx context.Context) (string, error)
+	WorkspaceNew(name string) error
+	WorkspaceNewContext(ctx context.Context, name string) error
+	WorkspaceSelect(name string) error
+	WorkspaceSelectContext(ctx context.Context, name string) error
+	WorkspaceDelete(name string, force bool) error
+	WorkspaceDeleteContext(ctx context.Context, name string, force bool) error
+	SelectOrCreateWorkspace(name string) error
+	SelectOrCreateWorkspaceContext(ctx context.Context, name string) error
+	Import(address, id string) error
+	ImportContext(ctx context.Context, address, id string) error
+	ImportAll(specs []ImportSpec) (*ImportReport, error)
+	ImportAllContext(ctx context.Context, specs []ImportSpec) (*ImportReport, error)
+	WriteImportBlocks(filename string, specs []ImportSpec) error
+	WriteImportBlocksContext(ctx context.Context, filename string, specs []ImportSpec) error
+	Validate() (*ValidateResult, error)
+	ValidateContext(ctx context.Context) (*ValidateResult, error)
+	Fmt(check bool, recursive bool) (*FmtResult, error)
+	FmtContext(ctx context.Context, check bool, recursive bool) (*FmtResult, error)
+	FmtDiff(recursive bool) (string, error)
+	FmtDiffContext(ctx context.Context, recursive bool) (string, error)
 	Dir() string
 	WithRegistry(credentials []RegistryCredential)
+	WithCredentialsSource(source CredentialsSource)
 	GetModule(moduleSource, version string) error
+	GetModuleContext(ctx context.Context, moduleSource, version string) error
+	GetModuleWithResult(moduleSource, version string) (*ModuleResult, error)
+	GetModuleWithResultContext(ctx context.Context, moduleSource, version string) (*ModuleResult, error)
 	WithBackendVars(backendVars map[string]string)
 	BackendVars() map[string]string
 	AppendBackendVars(backendVars map[string]string)
 	WithVars(vars map[string]string)
 	Vars() map[string]string
 	AppendVars(vars map[string]string)
+	WithCLIConfig(config CLIConfig)
+	CLIConfig() CLIConfig
+	WithTypedVars(vars map[string]interface{})
+	TypedVars() map[string]interface{}
+	AppendTypedVars(vars map[string]interface{})
 	WithEnv(env map[string]string)
 	Env() map[string]string
 	AppendEnv(env map[string]string)
 	ConfigFilePath() string
+	Close() error
 	Version() (string, error)
+	VersionContext(ctx context.Context) (string, error)
 	SetStdout(stdout io.Writer) Terraform
 	Stderr() io.Writer
 	Stdout() io.Writer
 	SetStderr(stderr io.Writer) Terraform
+	SetGracePeriod(gracePeriod time.Duration) Terraform
+	GracePeriod() time.Duration
+	SetEventHandler(handler EventHandler) Terraform
 
 	SetDir(dir string) Terraform
 }
@@ -53,6 +156,8 @@ type Version struct {
 // 		dir - Working directory used for terraform execution
 // 		stdout - default stdout for terraform execution
 // 		stderr - default stderr for terraform execution
+// Close must be called when the instance is not used anymore. Otherwise the 'tfcli-config-*'
+// temporary directory with the generated CLI configuration, which may contain registry tokens, is left behind.
 func New(tfBin, dir string) Terraform {
 	logrus.Debugf("New Terraform Client. Executable: '%s', Working Dir: '%s'", tfBin, dir)
 	return &terraform{
@@ -62,19 +167,28 @@ func New(tfBin, dir string) Terraform {
 		dir:         filepath.FromSlash(dir),
 		backendVars: map[string]string{},
 		vars:        map[string]string{},
+		typedVars:   map[string]interface{}{},
 		env:         map[string]string{},
+		gracePeriod: DefaultGracePeriod,
 	}
 }
 
 type terraform struct {
-	command     string
-	stdout      io.Writer
-	stderr      io.Writer
-	dir         string
-	backendVars map[string]string
-	vars        map[string]string
-	env         map[string]string
-	credentials []RegistryCredential
+	command      string
+	stdout       io.Writer
+	stderr       io.Writer
+	dir          string
+	backendVars  map[string]string
+	vars         map[string]string
+	typedVars    map[string]interface{}
+	env          map[string]string
+	credentials  []RegistryCredential
+	credsSource  CredentialsSource
+	cliConfig    CLIConfig
+	configFile   string
+	gracePeriod  time.Duration
+	eventHandler EventHandler
+	tfVersion    *version.Version
 }
 
 func (t *terraform) Stderr() io.Writer {
@@ -95,6 +209,26 @@ func (t *terraform) SetStderr(stderr io.Writer) Terraform {
 	return t
 }
 
+// SetGracePeriod sets the time terraform gets to exit after the context of
+// a command is done. Terraform is interrupted first so it can release state
+// locks and is killed once the grace period expired.
+func (t *terraform) SetGracePeriod(gracePeriod time.Duration) Terraform {
+	t.gracePeriod = gracePeriod
+	return t
+}
+
+func (t *terraform) GracePeriod() time.Duration {
+	return t.gracePeriod
+}
+
+// SetEventHandler enables the machine readable UI for plan, apply and destroy.
+// The handler is called for every event, the raw output is still written to stdout.
+// Set the handler to nil to disable the machine readable UI.
+func (t *terraform) SetEventHandler(handler EventHandler) Terraform {
+	t.eventHandler = handler
+	return t
+}
+
 func (t *terraform) SetDir(dir string) Terraform {
 	t.dir = dir
 	return t
@@ -109,18 +243,36 @@ func (t *terraform) WithRegistry(credentials []RegistryCredential) {
 	t.credentials = credentials
 }
 
+// WithCredentialsSource sets the source of registry tokens. Unlike WithRegistry
+// the tokens are passed as environment variables and not written to the CLI configuration file.
+func (t *terraform) WithCredentialsSource(source CredentialsSource) {
+	t.credsSource = source
+}
+
 // GetModule downloads the given module and prepares the workspace
 // Configure the terraform registry (WithRegistry) if module needs
 // credentials to be accessed
 func (t *terraform) GetModule(moduleSource, version string) error {
+	return t.GetModuleContext(context.Background(), moduleSource, version)
+}
+
+func (t *terraform) GetModuleContext(ctx context.Context, moduleSource, version string) error {
+	_, err := t.GetModuleWithResultContext(ctx, moduleSource, version)
+	return err
+}
+
+// GetModuleWithResult downloads the given module like GetModule and returns the source and version terraform fetched.
+// The source can be any module source terraform supports, e.g. a registry address, a git URL with '//subdir'
+// or a local path relative to the working directory. The version is only allowed for registry modules.
+func (t *terraform) GetModuleWithResult(moduleSource, version string) (*ModuleResult, error) {
+	return t.GetModuleWithResultContext(context.Background(), moduleSource, version)
+}
+
+func (t *terraform) GetModuleWithResultContext(ctx context.Context, moduleSource, version string) (*ModuleResult, error) {
 	logrus.Debugf("Terraform GetModule: %s (%s)", moduleSource, version)
-	err := t.writeConfig()
+	err := t.downloadModule(ctx, moduleSource, version)
 	if err != nil {
-		return err
-	}
-	err = t.downloadModule(moduleSource, version)
-	if err != nil {
-		return err
+		return nil, err
 	}
 	return t.copyModuleToWorkingDir()
 }
@@ -155,6 +307,37 @@ func (t *terraform) AppendVars(vars map[string]string) {
 	}
 }
 
+// WithCLIConfig sets the settings of the generated CLI configuration file,
+// e.g. a shared plugin cache or provider mirrors.
+func (t *terraform) WithCLIConfig(config CLIConfig) {
+	t.cliConfig = config
+}
+
+func (t *terraform) CLIConfig() CLIConfig {
+	return t.cliConfig
+}
+
+// WithTypedVars sets terraform variables of any type for plan/apply/destroy.
+// Values are JSON encoded (cty.Value is supported as well) and passed in a
+// generated variables file outside of the working directory instead of the command line.
+// Note: Variables set with WithVars or RunOptions.VarFiles take precedence.
+func (t *terraform) WithTypedVars(vars map[string]interface{}) {
+	t.typedVars = vars
+}
+
+func (t *terraform) TypedVars() map[string]interface{} {
+	return t.typedVars
+}
+
+func (t *terraform) AppendTypedVars(vars map[string]interface{}) {
+	if t.typedVars == nil {
+		t.typedVars = map[string]interface{}{}
+	}
+	for k, v := range vars {
+		t.typedVars[k] = v
+	}
+}
+
 // WithEnv sets envrionment variables for terraform execution
 func (t *terraform) WithEnv(env map[string]string) {
 	t.env = env
@@ -171,61 +354,240 @@ func (t *terraform) Env() map[string]string {
 }
 
 func (t *terraform) Init() error {
-	err := t.writeConfig()
-	if err != nil {
-		return err
-	}
+	return t.InitContext(context.Background())
+}
+
+func (t *terraform) InitContext(ctx context.Context) error {
 	backendArgs := mapToArgs(t.backendVars, "backend-config")
 	cmd := t.newCommand([]string{"init", "-no-color", "-input=false", "-force-copy", "-get=true"}, backendArgs)
-	return t.run(cmd)
+	return t.run(ctx, cmd)
 }
 
 func (t *terraform) Apply() error {
+	return t.ApplyContext(context.Background())
+}
+
+func (t *terraform) ApplyContext(ctx context.Context) error {
+	return t.ApplyWithOptionsContext(ctx, RunOptions{})
+}
+
+// ApplyWithOptions applies with additional options like targets or replacements.
+// The options only apply to this call.
+func (t *terraform) ApplyWithOptions(opts RunOptions) error {
+	return t.ApplyWithOptionsContext(context.Background(), opts)
+}
+
+func (t *terraform) ApplyWithOptionsContext(ctx context.Context, opts RunOptions) error {
+	varFileArgs, cleanup, err := t.writeVarsFile()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	optionArgs, err := t.runOptionsArgs(ctx, opts, "apply", false)
+	if err != nil {
+		return err
+	}
 	varsArgs := mapToArgs(t.vars, "var")
-	cmd := t.newCommand([]string{"apply", "-no-color", "-input=false", "-auto-approve"}, varsArgs)
-	return t.run(cmd)
+	cmd := t.newCommand([]string{"apply", "-no-color", "-input=false", "-auto-approve"}, t.uiArgs(), varFileArgs, optionArgs, varsArgs)
+	return t.runUI(ctx, cmd)
 }
 
 func (t *terraform) ApplyWithPlan(planFile string) error {
-	varsArgs := mapToArgs(t.vars, "var")
-	cmd := t.newCommand([]string{"apply", "-no-color", "-input=false", "-auto-approve"}, varsArgs)
-	return t.run(cmd)
+	return t.ApplyWithPlanContext(context.Background(), planFile)
+}
+
+// ApplyWithPlanContext applies the given saved plan file. Variables are not passed,
+// since they are part of the saved plan.
+func (t *terraform) ApplyWithPlanContext(ctx context.Context, planFile string) error {
+	return t.ApplyWithPlanAndOptionsContext(ctx, planFile, RunOptions{})
+}
+
+// ApplyWithPlanAndOptions applies the saved plan file with additional options.
+// Only parallelism and lock timeout are supported, all other options are part of the saved plan.
+func (t *terraform) ApplyWithPlanAndOptions(planFile string, opts RunOptions) error {
+	return t.ApplyWithPlanAndOptionsContext(context.Background(), planFile, opts)
+}
+
+func (t *terraform) ApplyWithPlanAndOptionsContext(ctx context.Context, planFile string, opts RunOptions) error {
+	planFile, err := t.resolvePlanFile(planFile)
+	if err != nil {
+		return err
+	}
+	optionArgs, err := t.runOptionsArgs(ctx, opts, "apply", true)
+	if err != nil {
+		return err
+	}
+	cmd := t.newCommand([]string{"apply", "-no-color", "-input=false", "-auto-approve"}, t.uiArgs(), optionArgs, []string{planFile})
+	err = t.runUI(ctx, cmd)
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) && cmdErr.contains("Saved plan is stale") {
+		return &StalePlanError{PlanFile: planFile, Err: err}
+	}
+	return err
 }
 
 func (t *terraform) Plan(planFile string) error {
+	return t.PlanContext(context.Background(), planFile)
+}
+
+func (t *terraform) PlanContext(ctx context.Context, planFile string) error {
+	return t.plan(ctx, planFile, RunOptions{})
+}
+
+// PlanWithResult runs plan with detailed exit code to detect pending changes.
+func (t *terraform) PlanWithResult(planFile string) (*PlanResult, error) {
+	return t.PlanWithResultContext(context.Background(), planFile)
+}
+
+func (t *terraform) PlanWithResultContext(ctx context.Context, planFile string) (*PlanResult, error) {
+	return t.PlanWithOptionsContext(ctx, planFile, RunOptions{})
+}
+
+// PlanWithOptions plans with additional options like targets or replacements and detects pending changes.
+// The options only apply to this call.
+func (t *terraform) PlanWithOptions(planFile string, opts RunOptions) (*PlanResult, error) {
+	return t.PlanWithOptionsContext(context.Background(), planFile, opts)
+}
+
+func (t *terraform) PlanWithOptionsContext(ctx context.Context, planFile string, opts RunOptions) (*PlanResult, error) {
+	err := t.plan(ctx, planFile, opts, "-detailed-exitcode")
+	// exit code 2 means the plan succeeded and contains changes
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) && cmdErr.ExitCode == 2 {
+		return &PlanResult{HasChanges: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &PlanResult{HasChanges: false}, nil
+}
+
+func (t *terraform) plan(ctx context.Context, planFile string, opts RunOptions, args ...string) error {
+	varFileArgs, cleanup, err := t.writeVarsFile()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	optionArgs, err := t.runOptionsArgs(ctx, opts, "plan", false)
+	if err != nil {
+		return err
+	}
 	varsArgs := mapToArgs(t.vars, "var")
 	if planFile != "" {
 		varsArgs = append(varsArgs, "-out", planFile)
 	}
-	cmd := t.newCommand([]string{"plan", "-no-color", "-input=false"}, varsArgs)
-	return t.run(cmd)
+	cmd := t.newCommand([]string{"plan", "-no-color", "-input=false"}, t.uiArgs(), args, varFileArgs, optionArgs, varsArgs)
+	return t.runUI(ctx, cmd)
 }
 
 func (t *terraform) Destroy() error {
+	return t.DestroyContext(context.Background())
+}
+
+func (t *terraform) DestroyContext(ctx context.Context) error {
+	return t.DestroyWithOptionsContext(ctx, RunOptions{})
+}
+
+// DestroyWithOptions destroys with additional options like targets.
+// The options only apply to this call.
+func (t *terraform) DestroyWithOptions(opts RunOptions) error {
+	return t.DestroyWithOptionsContext(context.Background(), opts)
+}
+
+func (t *terraform) DestroyWithOptionsContext(ctx context.Context, opts RunOptions) error {
+	varFileArgs, cleanup, err := t.writeVarsFile()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	optionArgs, err := t.runOptionsArgs(ctx, opts, "destroy", false)
+	if err != nil {
+		return err
+	}
 	varsArgs := mapToArgs(t.vars, "var")
-	cmd := t.newCommand([]string{"destroy", "-no-color", "-input=false", "-auto-approve"}, varsArgs)
+	cmd := t.newCommand([]string{"destroy", "-no-color", "-input=false", "-auto-approve"}, t.uiArgs(), varFileArgs, optionArgs, varsArgs)
 	// implementation of workaround, described in https://github.com/hashicorp/terraform/issues/18026
 	// Note: Make sure to not overwrite default envs set by "newCommand"
 	cmd.Env = append(cmd.Env, "TF_WARN_OUTPUT_ERRORS=1")
-	return t.run(cmd)
+	return t.runUI(ctx, cmd)
 }
 
 func (t *terraform) Output() (map[string]string, error) {
+	return t.OutputContext(context.Background())
+}
+
+func (t *terraform) OutputContext(ctx context.Context) (map[string]string, error) {
 	cmd := t.newCommand([]string{"output", "-json"})
 	buffer := bytes.Buffer{}
 	cmd.Stdout = &buffer
-	err := t.run(cmd)
+	err := t.run(ctx, cmd)
 	if err != nil {
 		return nil, err
 	}
 	return readOutVars(buffer.Bytes())
 }
 
+// Outputs returns the typed terraform outputs including their type and sensitivity.
+func (t *terraform) Outputs() (Outputs, error) {
+	return t.OutputsContext(context.Background())
+}
+
+func (t *terraform) OutputsContext(ctx context.Context) (Outputs, error) {
+	cmd := t.newCommand([]string{"output", "-json"})
+	buffer := bytes.Buffer{}
+	cmd.Stdout = &buffer
+	err := t.run(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return readOutputs(buffer.Bytes())
+}
+
+// ShowPlan returns the parsed representation of the saved plan file.
+func (t *terraform) ShowPlan(planFile string) (*Plan, error) {
+	return t.ShowPlanContext(context.Background(), planFile)
+}
+
+func (t *terraform) ShowPlanContext(ctx context.Context, planFile string) (*Plan, error) {
+	planFile, err := t.resolvePlanFile(planFile)
+	if err != nil {
+		return nil, err
+	}
+	cmd := t.newCommand([]string{"show", "-no-color", "-json", planFile})
+	buffer := bytes.Buffer{}
+	cmd.Stdout = &buffer
+	err = t.run(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return readPlan(buffer.Bytes())
+}
+
+// ShowState returns the resources and outputs of the current state.
+func (t *terraform) ShowState() (*State, error) {
+	return t.ShowStateContext(context.Background())
+}
+
+func (t *terraform) ShowStateContext(ctx context.Context) (*State, error) {
+	cmd := t.newCommand([]string{"show", "-no-color", "-json"})
+	buffer := bytes.Buffer{}
+	cmd.Stdout = &buffer
+	err := t.run(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return readState(buffer.Bytes())
+}
+
 func (t *terraform) Version() (string, error) {
+	return t.VersionContext(context.Background())
+}
+
+func (t *terraform) VersionContext(ctx context.Context) (string, error) {
 	cmd := t.newCommand([]string{"version", "-json"})
 	buffer := bytes.Buffer{}
 	cmd.Stdout = &buffer
-	err := t.run(cmd)
+	err := t.run(ctx, cmd)
 	if err != nil {
 		return "", err
 	}
@@ -239,33 +601,159 @@ func (t *terraform) Version() (string, error) {
 
 // private
 
+// terraformVersion returns the parsed version of the terraform executable.
+// The version is only detected once.
+func (t *terraform) terraformVersion(ctx context.Context) (*version.Version, error) {
+	if t.tfVersion != nil {
+		return t.tfVersion, nil
+	}
+	raw, err := t.VersionContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot detect terraform version: %w", err)
+	}
+	v, err := version.NewVersion(raw)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse terraform version '%s': %s", raw, err)
+	}
+	t.tfVersion = v
+	return v, nil
+}
+
 func (t *terraform) writeConfig() error {
-	if t.credentials == nil || len(t.credentials) == 0 {
+	if !t.hasConfig() {
 		return nil
 	}
-	err := writeTerraformConfig(t.ConfigFilePath(), t.credentials)
+	if t.cliConfig.PluginCacheDir != "" {
+		// terraform ignores a missing plugin cache directory
+		err := os.MkdirAll(t.cliConfig.PluginCacheDir, 0755)
+		if err != nil {
+			return fmt.Errorf("cannot create plugin cache directory: %s", err)
+		}
+	}
+	if t.configFile == "" {
+		// keep the configuration out of the working directory, it may contain credentials
+		dir, err := ioutil.TempDir("", "tfcli-config-")
+		if err != nil {
+			return fmt.Errorf("cannot create directory for terraform cli configuration: %s", err)
+		}
+		t.configFile = filepath.Join(dir, "terraform.tfrc")
+	}
+	err := writeTerraformConfig(t.configFile, t.credentials, t.cliConfig)
 	if err != nil {
-		return fmt.Errorf("cannot configure terraform registry credentials: %s", err)
+		return fmt.Errorf("cannot write terraform cli configuration: %s", err)
 	}
 	return nil
 }
 
-func (t *terraform) copyModuleToWorkingDir() error {
-	modulePath := filepath.Join(t.dir, ".terraform", "modules", "module")
+// hasConfig returns true if a CLI configuration file is required
+func (t *terraform) hasConfig() bool {
+	return len(t.credentials) > 0 || !t.cliConfig.isEmpty()
+}
+
+// writeVarsFile writes the typed variables into a private temporary directory outside
+// of the working directory and returns the '-var-file' argument to pass it to terraform.
+// The returned cleanup function removes the file again.
+func (t *terraform) writeVarsFile() ([]string, func(), error) {
+	if len(t.typedVars) == 0 {
+		return []string{}, func() {}, nil
+	}
+	// the variables may contain secrets, keep them out of the module
+	dir, err := ioutil.TempDir("", "tfcli-vars-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create directory for terraform variables file: %s", err)
+	}
+	cleanup := func() {
+		os.RemoveAll(dir)
+	}
+	file := filepath.Join(dir, varsFileName)
+	err = writeVarsFile(file, t.typedVars)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("cannot write terraform variables file: %s", err)
+	}
+	return []string{"-var-file=" + file}, cleanup, nil
+}
+
+// runOptionsArgs returns the arguments for the run options after validating
+// them against the terraform version.
+func (t *terraform) runOptionsArgs(ctx context.Context, opts RunOptions, command string, savedPlan bool) ([]string, error) {
+	args, err := opts.args(command, savedPlan)
+	if err != nil {
+		return nil, err
+	}
+	required := opts.requiredVersion()
+	if savedPlan || required == nil {
+		return args, nil
+	}
+	v, err := t.terraformVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if v.LessThan(required) {
+		return nil, fmt.Errorf("run options require terraform %s or later, got %s", required, v)
+	}
+	return args, nil
+}
+
+// resolvePlanFile returns the absolute path of the plan file and makes sure
+// it exists within the working directory.
+func (t *terraform) resolvePlanFile(planFile string) (string, error) {
+	if planFile == "" {
+		return "", fmt.Errorf("plan file must not be empty")
+	}
+	planFile = filepath.FromSlash(planFile)
+	if !filepath.IsAbs(planFile) {
+		planFile = filepath.Join(t.dir, planFile)
+	}
+	planFile, err := filepath.Abs(planFile)
+	if err != nil {
+		return "", err
+	}
+	if !isSubPath(t.dir, planFile) {
+		return "", fmt.Errorf("plan file '%s' is not located in working directory '%s'", planFile, t.dir)
+	}
+	if !fileExists(planFile) {
+		return "", fmt.Errorf("plan file '%s' does not exist", planFile)
+	}
+	return planFile, nil
+}
+
+// copyModuleToWorkingDir moves the fetched module into the working directory.
+// Local modules are not fetched by terraform and are copied instead.
+func (t *terraform) copyModuleToWorkingDir() (*ModuleResult, error) {
+	result, err := readModuleManifest(t.dir, moduleKey)
+	if err != nil {
+		return nil, fmt.Errorf("preparing terraform module failed, %s", err)
+	}
+	modulePath := result.Dir
+	if !filepath.IsAbs(modulePath) {
+		modulePath = filepath.Join(t.dir, modulePath)
+	}
+	if !isSubPath(filepath.Join(t.dir, ".terraform", "modules"), modulePath) {
+		// copying a parent directory would copy the working directory into itself
+		if isSubPath(modulePath, t.dir) {
+			return nil, fmt.Errorf("preparing terraform module failed, module source '%s' contains the working directory", result.Source)
+		}
+		err = copyDir(modulePath, t.dir)
+		if err != nil {
+			return nil, fmt.Errorf("preparing terraform module failed, cannot copy module source: %s", err)
+		}
+		return result, nil
+	}
 	list, err := ioutil.ReadDir(modulePath)
 	if err != nil {
-		return fmt.Errorf("preparing terraform module failed, cannot read module source: %s", err)
+		return nil, fmt.Errorf("preparing terraform module failed, cannot read module source: %s", err)
 	}
 	for _, f := range list {
 		err := os.Rename(filepath.Join(modulePath, f.Name()), filepath.Join(t.dir, f.Name()))
 		if err != nil {
-			return fmt.Errorf("preparing terraform module failed, can not move module source file: %s", err)
+			return nil, fmt.Errorf("preparing terraform module failed, can not move module source file: %s", err)
 		}
 	}
-	return nil
+	return result, nil
 }
 
-func (t *terraform) downloadModule(moduleSource, version string) error {
+func (t *terraform) downloadModule(ctx context.Context, moduleSource, version string) error {
 	file := filepath.Join(t.dir, "main.tf.json")
 	err := writeModuleFile(file, moduleSource, version)
 	if err != nil {
@@ -274,9 +762,9 @@ func (t *terraform) downloadModule(moduleSource, version string) error {
 	// Make sure to delete the temporary main file after downloading the module
 	defer os.Remove(file)
 	cmd := t.newCommand([]string{"get", "-no-color"})
-	err = t.run(cmd)
+	err = t.run(ctx, cmd)
 	if err != nil {
-		return fmt.Errorf("cannot download module '%s' version '%s': %s", moduleSource, version, err)
+		return fmt.Errorf("cannot download module '%s' version '%s': %w", moduleSource, version, err)
 	}
 	return nil
 }
@@ -293,18 +781,120 @@ func (t *terraform) newCommand(args ...[]string) *exec.Cmd {
 			cmd.Env = append(cmd.Env, k+"="+v)
 		}
 	}
-	if t.credentials != nil && len(t.credentials) > 0 {
-		cmd.Env = append(cmd.Env, "TF_CLI_CONFIG_FILE="+t.ConfigFilePath())
-	}
 	return cmd
 }
 
-func (t *terraform) run(cmd *exec.Cmd) error {
-	logrus.Debugf("Command Run: '%s'", cmd.String())
-	logrus.Debugf("Command Env: %+v", cmd.Env)
-	return cmd.Run()
+// uiArgs returns the arguments to enable the machine readable UI if an event handler is set.
+func (t *terraform) uiArgs() []string {
+	if t.eventHandler == nil {
+		return []string{}
+	}
+	return []string{"-json"}
+}
+
+// runUI runs the command and passes the machine readable UI events to the event handler.
+func (t *terraform) runUI(ctx context.Context, cmd *exec.Cmd) error {
+	if t.eventHandler == nil {
+		return t.run(ctx, cmd)
+	}
+	diagnostics := []Diagnostic{}
+	events := newEventWriter(func(event Event) {
+		if event.Type == EventDiagnostic && event.Diagnostic != nil && event.Diagnostic.Severity == "error" {
+			diagnostics = append(diagnostics, *event.Diagnostic)
+		}
+		t.eventHandler(event)
+	})
+	cmd.Stdout = io.MultiWriter(cmd.Stdout, events)
+	err := t.run(ctx, cmd)
+	// the handler must not append diagnostics anymore, run may return before the output is closed
+	events.Close()
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		cmdErr.Diagnostics = diagnostics
+	}
+	return err
 }
 
+// run executes the command and waits for it to finish. If the context is done
+// before the command exits, terraform is interrupted and killed after the
+// configured grace period. Failures are returned as *CommandError.
+func (t *terraform) run(ctx context.Context, cmd *exec.Cmd) error {
+	logrus.Debugf("Command Run: '%s'", strings.Join(redactArgs(cmd.Args), " "))
+	if t.hasConfig() {
+		err := t.writeConfig()
+		if err != nil {
+			return err
+		}
+		cmd.Env = append(cmd.Env, "TF_CLI_CONFIG_FILE="+t.configFile)
+	}
+	if t.credsSource != nil {
+		env, err := credentialsEnv(ctx, t.credsSource)
+		if err != nil {
+			return err
+		}
+		cmd.Env = append(cmd.Env, env...)
+	}
+	logrus.Debugf("Command Env: %+v", redactEnv(cmd.Env))
+	stderr := newTailWriter(stderrTailSize)
+	if cmd.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, stderr)
+	} else {
+		cmd.Stderr = stderr
+	}
+	setProcessGroup(cmd)
+	err := cmd.Start()
+	if err != nil {
+		return newCommandError(cmd, err, stderr.String())
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return newCommandError(cmd, err, stderr.String())
+		}
+		return nil
+	case <-ctx.Done():
+	}
+	logrus.Debugf("Command Interrupt: '%s' (%s)", cmd.Path, ctx.Err())
+	// Interrupt is not supported on all platforms (e.g. windows)
+	if err := interruptProcessGroup(cmd); err != nil {
+		killProcessGroup(cmd)
+	}
+	timer := time.NewTimer(t.gracePeriod)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		logrus.Debugf("Command Kill: '%s'", cmd.Path)
+		killProcessGroup(cmd)
+		timer.Reset(killWaitTimeout)
+		select {
+		case <-done:
+		case <-timer.C:
+			logrus.Debugf("Command Kill: '%s' did not close its output", cmd.Path)
+		}
+	}
+	return newCommandError(cmd, ctx.Err(), stderr.String())
+}
+
+// ConfigFilePath returns the path of the generated CLI configuration file.
+// The file is written by the first command which requires it, until then an empty string is returned.
+// Earlier versions always returned '<dir>/.terraformrc'.
+// The file is written to a private temporary directory which is removed by Close.
 func (t *terraform) ConfigFilePath() string {
-	return filepath.Join(t.dir, ".terraformrc")
+	return t.configFile
+}
+
+// Close removes the generated CLI configuration file.
+// The instance can still be used afterwards, the configuration is written again if required.
+func (t *terraform) Close() error {
+	if t.configFile == "" {
+		return nil
+	}
+	err := os.RemoveAll(filepath.Dir(t.configFile))
+	t.configFile = ""
+	return err
 }
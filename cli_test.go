@@ -3,12 +3,15 @@ package tfcli
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -41,6 +44,34 @@ func logBuffer(t *testing.T, out *bytes.Buffer) {
 	}
 }
 
+// fakeTerraform writes a shell script standing in for the terraform executable.
+func fakeTerraform(t *testing.T, script string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake terraform requires a posix shell")
+	}
+	file := filepath.Join(t.TempDir(), "terraform")
+	err := ioutil.WriteFile(file, []byte("#!/bin/sh\n"+script), 0755)
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "Cannot create fake terraform")
+	}
+	return file
+}
+
+// recordingTerraform returns a terraform instance using a fake terraform script.
+// The script records its arguments in the calls.log of the returned working directory, see readCalls.
+func recordingTerraform(t *testing.T, script string) (Terraform, string) {
+	tfbin := fakeTerraform(t, "echo \"$@\" >> calls.log\n"+script)
+	dir := t.TempDir()
+	return New(tfbin, dir), dir
+}
+
+// readCalls returns the arguments of the recorded terraform calls
+func readCalls(t *testing.T, dir string) []string {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "calls.log"))
+	must(t, err)
+	return readLines(raw)
+}
+
 func TestSetters(t *testing.T) {
 
 	tmpDir, err := ioutil.TempDir("", "")
@@ -55,7 +86,7 @@ func TestSetters(t *testing.T) {
 	}
 
 	assert.Equal(t, tmpDir, tf.Dir())
-	assert.Equal(t, filepath.Join(tmpDir, ".terraformrc"), tf.ConfigFilePath())
+	assert.Empty(t, tf.ConfigFilePath())
 
 	envs := map[string]string{
 		"hello": "world",
@@ -88,6 +119,7 @@ func TestSetters(t *testing.T) {
 	if err != nil {
 		assert.Fail(t, err.Error())
 	}
+	defer tf.Close()
 	raw, err := ioutil.ReadFile(tf.ConfigFilePath())
 	assert.NoError(t, err)
 	assert.True(t, strings.Contains(string(raw), "type"), "Config must contain 'type'")
@@ -230,3 +262,265 @@ func TestCli(t *testing.T) {
 		assert.FailNow(t, "destroy failed")
 	}
 }
+
+func TestContextInterrupt(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+trap 'kill $!; echo interrupted; exit 1' INT
+sleep 10 >/dev/null 2>&1 &
+wait
+`)
+	out := &bytes.Buffer{}
+	tf := New(tfbin, t.TempDir())
+	tf.SetStdout(out)
+	assert.Equal(t, DefaultGracePeriod, tf.GracePeriod())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := tf.ApplyContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Contains(t, out.String(), "interrupted")
+}
+
+func TestContextKillAfterGracePeriod(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+trap '' INT
+exec sleep 10
+`)
+	tf := New(tfbin, t.TempDir())
+	tf.SetGracePeriod(100 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	err := tf.PlanContext(ctx, "")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestContextKillProcessGroup(t *testing.T) {
+	// the child ignores the interrupt and keeps stdout open
+	tfbin := fakeTerraform(t, `
+sleep 30 &
+wait
+`)
+	tf := New(tfbin, t.TempDir())
+	tf.SetStdout(&bytes.Buffer{})
+	tf.SetGracePeriod(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := tf.ApplyContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), killWaitTimeout)
+}
+
+func TestContextKillWaitTimeout(t *testing.T) {
+	if _, err := exec.LookPath("setsid"); err != nil {
+		t.Skip("setsid is not available")
+	}
+	// the child leaves the process group and cannot be killed with it
+	tfbin := fakeTerraform(t, `
+setsid sleep 2 &
+wait
+`)
+	defer func(timeout time.Duration) { killWaitTimeout = timeout }(killWaitTimeout)
+	killWaitTimeout = 100 * time.Millisecond
+	tf := New(tfbin, t.TempDir())
+	tf.SetStdout(&bytes.Buffer{})
+	tf.SetGracePeriod(100 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := tf.ApplyContext(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestApplyWithPlan(t *testing.T) {
+	tfbin := fakeTerraform(t, `echo "$@"`)
+	tmpDir := t.TempDir()
+	out := &bytes.Buffer{}
+	tf := New(tfbin, tmpDir)
+	tf.SetStdout(out)
+	tf.WithVars(map[string]string{
+		"myvar": "var_value",
+	})
+
+	err := tf.ApplyWithPlan("")
+	assert.Error(t, err)
+	err = tf.ApplyWithPlan("missing.tfplan")
+	assert.Error(t, err)
+	outside := filepath.Join(t.TempDir(), "outside.tfplan")
+	must(t, ioutil.WriteFile(outside, []byte{}, 0644))
+	err = tf.ApplyWithPlan(outside)
+	assert.Error(t, err)
+
+	planfile := filepath.Join(tmpDir, "my.tfplan")
+	must(t, ioutil.WriteFile(planfile, []byte{}, 0644))
+	err = tf.ApplyWithPlan("my.tfplan")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), planfile)
+	assert.NotContains(t, out.String(), "-var")
+
+	out.Reset()
+	err = tf.ApplyWithPlanAndOptions("my.tfplan", RunOptions{Parallelism: 2})
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "-parallelism=2")
+	err = tf.ApplyWithPlanAndOptions("my.tfplan", RunOptions{Targets: []string{"null_resource.a"}})
+	assert.Error(t, err)
+}
+
+func TestApplyWithStalePlan(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+echo "Error: Saved plan is stale" >&2
+exit 1
+`)
+	tmpDir := t.TempDir()
+	planfile := filepath.Join(tmpDir, "my.tfplan")
+	must(t, ioutil.WriteFile(planfile, []byte{}, 0644))
+	tf := New(tfbin, tmpDir)
+
+	err := tf.ApplyWithPlan(planfile)
+	var staleErr *StalePlanError
+	if assert.ErrorAs(t, err, &staleErr) {
+		assert.Equal(t, planfile, staleErr.PlanFile)
+	}
+}
+
+func TestTypedVars(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+for arg in "$@"; do
+	case "$arg" in
+		-var-file=*) cat "${arg#-var-file=}"; echo "${arg#-var-file=}" > varfile.txt ;;
+	esac
+done
+ls -a | grep tfvars || true
+`)
+	tmpDir := t.TempDir()
+	out := &bytes.Buffer{}
+	tf := New(tfbin, tmpDir)
+	tf.SetStdout(out)
+	tf.WithTypedVars(map[string]interface{}{
+		"list": []string{"a", "b"},
+	})
+	tf.AppendTypedVars(map[string]interface{}{
+		"secret": "s3cr3t",
+	})
+	assert.Len(t, tf.TypedVars(), 2)
+
+	err := tf.Plan("")
+	if !assert.NoError(t, err) {
+		logBuffer(t, out)
+		assert.FailNow(t, "plan failed")
+	}
+	assert.JSONEq(t, `{"list": ["a", "b"], "secret": "s3cr3t"}`, out.String())
+	// the file is written outside of the module and removed afterwards
+	raw, err := ioutil.ReadFile(filepath.Join(tmpDir, "varfile.txt"))
+	must(t, err)
+	varFile := strings.TrimSpace(string(raw))
+	assert.False(t, isSubPath(tmpDir, varFile), varFile)
+	assert.NoFileExists(t, varFile)
+	assert.NoDirExists(t, filepath.Dir(varFile))
+}
+
+func TestPlanWithResult(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+echo "$@"
+exit $EXIT_CODE
+`)
+	out := &bytes.Buffer{}
+	tf := New(tfbin, t.TempDir())
+	tf.SetStdout(out)
+
+	tf.WithEnv(map[string]string{"EXIT_CODE": "0"})
+	res, err := tf.PlanWithResult("")
+	if assert.NoError(t, err) {
+		assert.False(t, res.HasChanges)
+	}
+	assert.Contains(t, out.String(), "-detailed-exitcode")
+
+	tf.WithEnv(map[string]string{"EXIT_CODE": "2"})
+	res, err = tf.PlanWithResult("")
+	if assert.NoError(t, err) {
+		assert.True(t, res.HasChanges)
+	}
+
+	tf.WithEnv(map[string]string{"EXIT_CODE": "1"})
+	_, err = tf.PlanWithResult("")
+	assert.Error(t, err)
+}
+
+func TestCLIConfig(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+if [ -n "$TF_CLI_CONFIG_FILE" ]; then
+	cp "$TF_CLI_CONFIG_FILE" used.tfrc
+fi
+`)
+	tmpDir := t.TempDir()
+	pluginCache := filepath.Join(t.TempDir(), "plugins")
+	tf := New(tfbin, tmpDir)
+
+	// no config file without settings
+	must(t, tf.Init())
+	assert.NoFileExists(t, tf.ConfigFilePath())
+
+	config := CLIConfig{
+		PluginCacheDir: pluginCache,
+		ProviderInstallation: []ProviderInstallationMethod{
+			{Type: InstallFilesystemMirror, Path: "/usr/share/terraform/providers"},
+		},
+	}
+	tf.WithCLIConfig(config)
+	assert.Equal(t, config, tf.CLIConfig())
+	must(t, tf.Init())
+	assert.DirExists(t, pluginCache)
+	raw, err := ioutil.ReadFile(filepath.Join(tmpDir, "used.tfrc"))
+	must(t, err)
+	assert.Contains(t, string(raw), `plugin_cache_dir = "`+pluginCache+`"`)
+	assert.Contains(t, string(raw), "filesystem_mirror {")
+}
+
+func TestConfigFileCleanup(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+echo "$TF_CLI_CONFIG_FILE" > config.path
+`)
+	tmpDir := t.TempDir()
+	tf := New(tfbin, tmpDir)
+	tf.WithRegistry([]RegistryCredential{{Type: "app.terraform.io", Token: "secret"}})
+	must(t, tf.Init())
+
+	configFile := tf.ConfigFilePath()
+	if !assert.FileExists(t, configFile) {
+		assert.FailNow(t, "config file not written")
+	}
+	raw, err := ioutil.ReadFile(filepath.Join(tmpDir, "config.path"))
+	must(t, err)
+	assert.Equal(t, configFile, strings.TrimSpace(string(raw)))
+
+	// nothing is written into the working directory
+	entries, err := ioutil.ReadDir(tmpDir)
+	must(t, err)
+	assert.Len(t, entries, 1)
+	assert.NotContains(t, configFile, tmpDir)
+
+	info, err := os.Stat(configFile)
+	must(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	info, err = os.Stat(filepath.Dir(configFile))
+	must(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+
+	// the same file is reused for all commands
+	must(t, tf.Apply())
+	assert.Equal(t, configFile, tf.ConfigFilePath())
+
+	must(t, tf.Close())
+	assert.NoFileExists(t, configFile)
+	assert.NoDirExists(t, filepath.Dir(configFile))
+	assert.Empty(t, tf.ConfigFilePath())
+	must(t, tf.Close())
+}
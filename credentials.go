@@ -0,0 +1,89 @@
+package tfcli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tokenEnvPrefix is the prefix of the environment variables terraform reads registry tokens from
+const tokenEnvPrefix = "TF_TOKEN_"
+
+// CredentialsSource provides the registry tokens for a terraform command.
+// The tokens are fetched right before every command and passed as TF_TOKEN_<host>
+// environment variables, so they are never written to disk. Requires terraform 1.2 or later.
+type CredentialsSource interface {
+	// Credentials returns the tokens by hostname, e.g. 'app.terraform.io'
+	Credentials(ctx context.Context) (map[string]string, error)
+}
+
+// StaticCredentials is a CredentialsSource with fixed tokens by hostname
+type StaticCredentials map[string]string
+
+func (c StaticCredentials) Credentials(ctx context.Context) (map[string]string, error) {
+	return c, nil
+}
+
+// CredentialsFunc adapts a function to a CredentialsSource, e.g. to read tokens from a secret store
+type CredentialsFunc func(ctx context.Context) (map[string]string, error)
+
+func (f CredentialsFunc) Credentials(ctx context.Context) (map[string]string, error) {
+	return f(ctx)
+}
+
+// CredentialsHelper configures an external program terraform asks for credentials.
+// See https://www.terraform.io/cli/config/config-file#credentials-helpers
+type CredentialsHelper struct {
+	// Name of the helper; terraform runs 'terraform-credentials-<name>' from the plugin directory
+	Name string   `hcl:"name,label"`
+	Args []string `hcl:"args"`
+}
+
+// credentialsEnv returns the environment variables for the tokens of the source
+func credentialsEnv(ctx context.Context, source CredentialsSource) ([]string, error) {
+	tokens, err := source.Credentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch registry credentials: %w", err)
+	}
+	env := []string{}
+	for host, token := range tokens {
+		name, err := tokenEnvName(host)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, name+"="+token)
+	}
+	sort.Strings(env)
+	return env, nil
+}
+
+// tokenEnvName returns the name of the token environment variable for the host.
+// Periods are encoded as underscores and dashes as double underscores.
+func tokenEnvName(host string) (string, error) {
+	if host == "" {
+		return "", fmt.Errorf("invalid registry host: empty hostname")
+	}
+	for _, r := range host {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+		default:
+			return "", fmt.Errorf("invalid registry host '%s': hosts with '%c' cannot be passed as environment variable", host, r)
+		}
+	}
+	name := strings.ReplaceAll(host, "-", "__")
+	name = strings.ReplaceAll(name, ".", "_")
+	return tokenEnvPrefix + name, nil
+}
+
+// redactEnv replaces the values of registry token environment variables
+func redactEnv(env []string) []string {
+	redactedEnv := make([]string, len(env))
+	for i, e := range env {
+		redactedEnv[i] = e
+		if kv := strings.SplitN(e, "=", 2); len(kv) == 2 && strings.HasPrefix(strings.ToUpper(kv[0]), tokenEnvPrefix) {
+			redactedEnv[i] = kv[0] + "=" + redacted
+		}
+	}
+	return redactedEnv
+}
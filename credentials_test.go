@@ -0,0 +1,85 @@
+package tfcli
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenEnvName(t *testing.T) {
+	for host, expected := range map[string]string{
+		"app.terraform.io":          "TF_TOKEN_app_terraform_io",
+		"my-registry.example.com":   "TF_TOKEN_my__registry_example_com",
+		"registry.example.com":      "TF_TOKEN_registry_example_com",
+		"localhost":                 "TF_TOKEN_localhost",
+		"tf-1.internal-example.net": "TF_TOKEN_tf__1_internal__example_net",
+	} {
+		name, err := tokenEnvName(host)
+		if assert.NoError(t, err, host) {
+			assert.Equal(t, expected, name)
+		}
+	}
+	for _, host := range []string{"", "example.com:8443", "exämple.com"} {
+		_, err := tokenEnvName(host)
+		assert.Error(t, err, host)
+	}
+}
+
+func TestRedactEnv(t *testing.T) {
+	assert.Equal(t,
+		[]string{"HOME=/root", "TF_TOKEN_app_terraform_io=" + redacted, "tf_token_example_com=" + redacted},
+		redactEnv([]string{"HOME=/root", "TF_TOKEN_app_terraform_io=secret", "tf_token_example_com=secret"}),
+	)
+}
+
+func TestCredentialsSource(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+env | grep TF_TOKEN_ | sort > tokens.env
+`)
+	tmpDir := t.TempDir()
+	tf := New(tfbin, tmpDir)
+
+	fetched := 0
+	tf.WithCredentialsSource(CredentialsFunc(func(ctx context.Context) (map[string]string, error) {
+		fetched++
+		return map[string]string{
+			"app.terraform.io":        "token1",
+			"my-registry.example.com": "token2",
+		}, nil
+	}))
+	must(t, tf.Init())
+	must(t, tf.Apply())
+	assert.Equal(t, 2, fetched)
+	assert.NoFileExists(t, tf.ConfigFilePath())
+	raw, err := ioutil.ReadFile(filepath.Join(tmpDir, "tokens.env"))
+	must(t, err)
+	assert.Equal(t, "TF_TOKEN_app_terraform_io=token1\nTF_TOKEN_my__registry_example_com=token2\n", string(raw))
+
+	tf.WithCredentialsSource(StaticCredentials{"example.com:8443": "token"})
+	assert.Error(t, tf.Init())
+
+	errVault := errors.New("vault is sealed")
+	tf.WithCredentialsSource(CredentialsFunc(func(ctx context.Context) (map[string]string, error) {
+		return nil, errVault
+	}))
+	assert.ErrorIs(t, tf.Init(), errVault)
+}
+
+func TestCredentialsHelperConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.tfrc")
+	err := writeTerraformConfig(file, nil, CLIConfig{
+		CredentialsHelper: &CredentialsHelper{Name: "vault", Args: []string{"--role", "ci"}},
+	})
+	must(t, err)
+	raw, err := ioutil.ReadFile(file)
+	must(t, err)
+	assert.Equal(t, `
+credentials_helper "vault" {
+  args = ["--role", "ci"]
+}
+`, string(raw))
+}
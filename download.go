@@ -0,0 +1,279 @@
+package tfcli
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	getter "github.com/hashicorp/go-getter"
+	"github.com/sirupsen/logrus"
+)
+
+// Downloader downloads verified terraform releases into a local cache
+type Downloader interface {
+	Download(version string, force bool) (string, error)
+	DownloadContext(ctx context.Context, version string, force bool) (string, error)
+	DownloadForModule(dir string, force bool) (string, error)
+	DownloadForModuleContext(ctx context.Context, dir string, force bool) (string, error)
+	Resolve(constraint string) (string, error)
+	ResolveContext(ctx context.Context, constraint string) (string, error)
+	SetBaseURL(baseURL string) Downloader
+	BaseURL() string
+	SetCacheDir(dir string) Downloader
+	CacheDir() string
+	SetHTTPClient(client *http.Client) Downloader
+	SetPlatform(goos, goarch string) Downloader
+	Platform() (goos string, goarch string)
+	SetOffline(offline bool) Downloader
+	Offline() bool
+	SetPublicKey(publicKey string) Downloader
+	SetIndexTTL(ttl time.Duration) Downloader
+	IndexTTL() time.Duration
+	ListCachedVersions() ([]CachedVersion, error)
+	RemoveCachedVersion(version string) error
+	PruneCache(keep int, olderThan time.Duration) ([]CachedVersion, error)
+	CacheSize() (int64, error)
+}
+
+type downloader struct {
+	baseURL   string
+	cacheDir  string
+	client    *http.Client
+	goos      string
+	goarch    string
+	offline   bool
+	publicKey string
+	indexTTL  time.Duration
+}
+
+// NewDownloader creates a downloader for the official releases of the current platform.
+// Releases are cached in '~/.tf/cache/terraform' unless another cache directory is set.
+func NewDownloader() Downloader {
+	return &downloader{
+		baseURL:   defaultReleasesURL,
+		client:    http.DefaultClient,
+		goos:      runtime.GOOS,
+		goarch:    runtime.GOARCH,
+		publicKey: hashicorpPublicKey,
+		indexTTL:  DefaultIndexTTL,
+	}
+}
+
+// SetBaseURL sets the release server, e.g. a mirror of releases.hashicorp.com.
+// The server must provide the same layout: <baseURL>/terraform/<version>/<file>
+func (d *downloader) SetBaseURL(baseURL string) Downloader {
+	d.baseURL = baseURL
+	return d
+}
+
+func (d *downloader) BaseURL() string {
+	return d.baseURL
+}
+
+// SetCacheDir sets the directory where the releases are stored
+func (d *downloader) SetCacheDir(dir string) Downloader {
+	d.cacheDir = dir
+	return d
+}
+
+// CacheDir returns the cache directory or an empty string if the default cache directory is used
+func (d *downloader) CacheDir() string {
+	return d.cacheDir
+}
+
+// SetHTTPClient sets the client used for downloads, e.g. to configure a proxy
+func (d *downloader) SetHTTPClient(client *http.Client) Downloader {
+	d.client = client
+	return d
+}
+
+// SetPlatform sets the operating system and architecture of the downloaded binary
+func (d *downloader) SetPlatform(goos, goarch string) Downloader {
+	d.goos = goos
+	d.goarch = goarch
+	return d
+}
+
+func (d *downloader) Platform() (string, string) {
+	return d.goos, d.goarch
+}
+
+// SetOffline enables the offline mode. Versions are only resolved from the cache.
+func (d *downloader) SetOffline(offline bool) Downloader {
+	d.offline = offline
+	return d
+}
+
+func (d *downloader) Offline() bool {
+	return d.offline
+}
+
+// SetPublicKey sets the armored PGP key used to verify the checksums of a release
+func (d *downloader) SetPublicKey(publicKey string) Downloader {
+	d.publicKey = publicKey
+	return d
+}
+
+// Download returns the path to the terraform binary of the given version.
+// The version is either an exact version or a constraint like '~> 1.5' which is resolved first.
+// The release is only downloaded if it is not cached yet or force is true.
+func (d *downloader) Download(version string, force bool) (string, error) {
+	return d.DownloadContext(context.Background(), version, force)
+}
+
+func (d *downloader) DownloadContext(ctx context.Context, version string, force bool) (string, error) {
+	if !isExactVersion(version) {
+		resolved, err := d.ResolveContext(ctx, version)
+		if err != nil {
+			return "", err
+		}
+		version = resolved
+	}
+	dir, err := d.versionDir(version)
+	if err != nil {
+		return "", err
+	}
+	tffile := filepath.Join(dir, d.executableName())
+	if !fileExists(tffile) || force {
+		if d.offline {
+			return "", fmt.Errorf("terraform %s (%s_%s) is not cached in '%s' and downloads are disabled", version, d.goos, d.goarch, filepath.Dir(dir))
+		}
+		// concurrent downloads of the same release within the process are done once,
+		// a forced download never joins a download which keeps an existing release
+		tffile, err = downloads.do(ctx, fmt.Sprintf("%s|force=%t", dir, force), func() (string, error) {
+			return d.lockedDownload(ctx, version, dir, force)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	err = touchLastUsed(dir)
+	if err != nil {
+		logrus.Debugf("Cannot record last use of '%s': %s", dir, err)
+	}
+	return tffile, nil
+}
+
+// downloads deduplicates concurrent downloads by their target directory
+var downloads = &flightGroup{}
+
+// lockedDownload downloads the release while holding a lock on the target directory,
+// so that other processes sharing the cache never see a partial download.
+func (d *downloader) lockedDownload(ctx context.Context, version, dir string, force bool) (string, error) {
+	err := os.MkdirAll(filepath.Dir(dir), 0755)
+	if err != nil {
+		return "", err
+	}
+	unlock, err := lockFile(ctx, dir+".lock")
+	if err != nil {
+		return "", fmt.Errorf("cannot lock '%s': %w", dir, err)
+	}
+	defer unlock()
+
+	// another process may have finished the download while we waited for the lock
+	tffile := filepath.Join(dir, d.executableName())
+	if fileExists(tffile) && !force {
+		return tffile, nil
+	}
+	err = d.download(ctx, version, dir)
+	if err != nil {
+		return "", err
+	}
+	return tffile, nil
+}
+
+// download fetches the release archive, verifies its checksum against
+// the signed checksums of the release and extracts it into dir.
+// The release is extracted into a temporary directory first and then renamed to dir.
+func (d *downloader) download(ctx context.Context, version, dir string) error {
+	checksums, err := fetchChecksums(ctx, d.client, d.baseURL, d.publicKey, version)
+	if err != nil {
+		return fmt.Errorf("cannot verify terraform %s: %s", version, err)
+	}
+	zipName := releaseZipName(version, d.goos, d.goarch)
+	expected, ok := checksums[zipName]
+	if !ok {
+		return fmt.Errorf("cannot verify terraform %s: no checksum for '%s'", version, zipName)
+	}
+
+	tmpDir, err := ioutil.TempDir(filepath.Dir(dir), "."+filepath.Base(dir)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	archive := filepath.Join(tmpDir, zipName)
+	checksum, err := downloadFile(ctx, d.client, releaseFileURL(d.baseURL, version, zipName), archive)
+	if err != nil {
+		return err
+	}
+	if checksum != expected {
+		return fmt.Errorf("checksum mismatch for '%s': expected %s, got %s", zipName, expected, checksum)
+	}
+
+	extracted := filepath.Join(tmpDir, "release")
+	err = (&getter.ZipDecompressor{}).Decompress(extracted, archive, true, 0)
+	if err != nil {
+		return err
+	}
+	tffile := filepath.Join(extracted, d.executableName())
+	if !fileExists(tffile) {
+		f, _ := ioutil.ReadDir(extracted)
+		list := []string{}
+		for _, e := range f {
+			list = append(list, e.Name())
+		}
+		return fmt.Errorf("Terraform executable not found: %s, Content: %+v", tffile, list)
+	}
+
+	// move an existing release out of the way, a directory cannot be replaced by rename
+	previous := filepath.Join(tmpDir, "previous")
+	err = os.Rename(dir, previous)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = os.Rename(extracted, dir)
+	if err != nil {
+		os.Rename(previous, dir)
+		return err
+	}
+	return nil
+}
+
+// versionDir returns the cache directory of the version: '<version>_<os>_<arch>'.
+// The platform is always part of the name, since the cache may be shared across platforms.
+func (d *downloader) versionDir(version string) (string, error) {
+	cacheDir, err := d.resolvedCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheDir, fmt.Sprintf("%s_%s_%s", version, d.goos, d.goarch)), nil
+}
+
+// resolvedCacheDir returns the configured or the default cache directory
+func (d *downloader) resolvedCacheDir() (string, error) {
+	if d.cacheDir != "" {
+		return d.cacheDir, nil
+	}
+	return defaultCacheDir()
+}
+
+func (d *downloader) executableName() string {
+	if d.goos == "windows" {
+		return "terraform.exe"
+	}
+	return "terraform"
+}
+
+// defaultCacheDir returns the default directory for downloaded terraform releases
+func defaultCacheDir() (string, error) {
+	userDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(userDir, ".tf", "cache", "terraform"), nil
+}
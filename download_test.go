@@ -0,0 +1,200 @@
+package tfcli
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testDownloader(t *testing.T, release *testRelease) Downloader {
+	return NewDownloader().
+		SetBaseURL(release.server.URL).
+		SetHTTPClient(release.server.Client()).
+		SetPublicKey(release.publicKey).
+		SetCacheDir(t.TempDir())
+}
+
+// cachedDirName returns the cache directory name of the version for the current platform
+func cachedDirName(version string) string {
+	return version + "_" + runtime.GOOS + "_" + runtime.GOARCH
+}
+
+func TestDownloader(t *testing.T) {
+	release := newTestRelease(t, "1.1.6")
+	d := testDownloader(t, release)
+
+	tffile, err := d.Download("1.1.6", false)
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "download failed")
+	}
+	assert.FileExists(t, tffile)
+	assert.Equal(t, filepath.Join(d.CacheDir(), cachedDirName("1.1.6")), filepath.Dir(tffile))
+
+	if runtime.GOOS != "windows" {
+		ver, err := New(tffile, t.TempDir()).Version()
+		assert.NoError(t, err)
+		assert.Equal(t, "1.1.6", ver)
+	}
+
+	// cached versions are not downloaded again
+	requests := release.requestCount()
+	cached, err := d.Download("1.1.6", false)
+	assert.NoError(t, err)
+	assert.Equal(t, tffile, cached)
+	assert.Equal(t, requests, release.requestCount())
+
+	_, err = d.Download("1.1.6", true)
+	assert.NoError(t, err)
+	assert.Greater(t, release.requestCount(), requests)
+}
+
+func TestDownloaderPlatform(t *testing.T) {
+	release := newTestRelease(t, "1.1.6")
+	d := testDownloader(t, release).SetPlatform("windows", "arm64")
+	goos, goarch := d.Platform()
+	assert.Equal(t, "windows", goos)
+	assert.Equal(t, "arm64", goarch)
+
+	tffile, err := d.Download("1.1.6", false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "terraform.exe", filepath.Base(tffile))
+		if runtime.GOOS != "windows" || runtime.GOARCH != "arm64" {
+			assert.Equal(t, filepath.Join(d.CacheDir(), "1.1.6_windows_arm64"), filepath.Dir(tffile))
+		}
+	}
+
+	_, err = d.SetPlatform("plan9", "mips").Download("1.1.6", false)
+	assert.Error(t, err)
+}
+
+func TestDownloaderOffline(t *testing.T) {
+	release := newTestRelease(t, "1.1.6")
+	d := testDownloader(t, release).SetOffline(true)
+	assert.True(t, d.Offline())
+
+	_, err := d.Download("1.1.6", false)
+	assert.Error(t, err)
+	assert.Zero(t, release.requestCount())
+
+	tffile, err := d.SetOffline(false).Download("1.1.6", false)
+	must(t, err)
+	requests := release.requestCount()
+
+	cached, err := d.SetOffline(true).Download("1.1.6", false)
+	assert.NoError(t, err)
+	assert.Equal(t, tffile, cached)
+	assert.Equal(t, requests, release.requestCount())
+
+	_, err = d.Download("1.1.6", true)
+	assert.Error(t, err)
+}
+
+func TestDownloaderFailsClosed(t *testing.T) {
+	release := newTestRelease(t, "1.1.6")
+	zipPath := "/terraform/1.1.6/" + releaseZipName("1.1.6", runtime.GOOS, runtime.GOARCH)
+
+	// unknown signing key
+	other := newTestRelease(t)
+	_, err := testDownloader(t, release).SetPublicKey(other.publicKey).Download("1.1.6", false)
+	assert.Error(t, err)
+
+	// missing release
+	_, err = testDownloader(t, release).Download("1.2.0", false)
+	assert.Error(t, err)
+
+	// tampered archive
+	release.files[zipPath] = testReleaseZip(t, "6.6.6", runtime.GOOS)
+	d := testDownloader(t, release)
+	_, err = d.Download("1.1.6", false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "checksum mismatch")
+	}
+	assert.NoDirExists(t, filepath.Join(d.CacheDir(), cachedDirName("1.1.6")))
+
+	// tampered checksums
+	sumsPath := "/terraform/1.1.6/terraform_1.1.6_SHA256SUMS"
+	release.files[sumsPath] = append(release.files[sumsPath], '\n')
+	_, err = testDownloader(t, release).Download("1.1.6", false)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "signature")
+	}
+}
+
+func TestDownloaderConcurrent(t *testing.T) {
+	release := newTestRelease(t, "1.1.6")
+	cacheDir := t.TempDir()
+
+	wg := sync.WaitGroup{}
+	files := make([]string, 8)
+	errs := make([]error, len(files))
+	for i := range files {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// separate downloaders share the cache like separate processes
+			files[i], errs[i] = testDownloader(t, release).SetCacheDir(cacheDir).Download("1.1.6", false)
+		}(i)
+	}
+	wg.Wait()
+	for i := range files {
+		assert.NoError(t, errs[i])
+		assert.FileExists(t, files[i])
+	}
+
+	zipDownloads := 0
+	release.mu.Lock()
+	for _, path := range release.requests {
+		if strings.HasSuffix(path, ".zip") {
+			zipDownloads++
+		}
+	}
+	release.mu.Unlock()
+	assert.Equal(t, 1, zipDownloads)
+
+	// no temporary directories are left behind
+	entries, err := ioutil.ReadDir(cacheDir)
+	must(t, err)
+	for _, entry := range entries {
+		assert.False(t, strings.HasPrefix(entry.Name(), "."), entry.Name())
+	}
+}
+
+func TestDownloaderWaitsForLock(t *testing.T) {
+	release := newTestRelease(t, "1.1.6")
+	d := testDownloader(t, release)
+	dir := filepath.Join(d.CacheDir(), cachedDirName("1.1.6"))
+
+	// simulate another process holding the lock
+	unlock, err := lockFile(context.Background(), dir+".lock")
+	must(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*lockRetryInterval)
+	defer cancel()
+	_, err = d.DownloadContext(ctx, "1.1.6", false)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Zero(t, release.requestCount())
+	unlock()
+
+	_, err = d.Download("1.1.6", false)
+	assert.NoError(t, err)
+}
+
+func TestDownloaderReplacesPartialDownload(t *testing.T) {
+	release := newTestRelease(t, "1.1.6")
+	d := testDownloader(t, release)
+	dir := filepath.Join(d.CacheDir(), cachedDirName("1.1.6"))
+	must(t, os.MkdirAll(dir, 0755))
+	must(t, ioutil.WriteFile(filepath.Join(dir, "partial"), []byte{}, 0644))
+
+	tffile, err := d.Download("1.1.6", false)
+	if assert.NoError(t, err) {
+		assert.FileExists(t, tffile)
+		assert.NoFileExists(t, filepath.Join(dir, "partial"))
+	}
+}
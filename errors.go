@@ -0,0 +1,218 @@
+package tfcli
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// stderrTailSize is the number of bytes of stderr kept for a CommandError
+const stderrTailSize = 8 * 1024
+
+// CommandError is returned if a terraform command fails
+type CommandError struct {
+	// Command is the terraform subcommand, e.g. 'apply' or 'state mv'
+	Command string
+	// Args are the command line arguments with redacted variable values
+	Args []string
+	// ExitCode is the exit code of terraform or -1 if terraform did not exit normally
+	ExitCode int
+	// Stderr is the tail of the error output
+	Stderr string
+	// Diagnostics are the errors reported by the machine readable UI
+	Diagnostics []Diagnostic
+	// Err is the error of the command execution, e.g. an *exec.ExitError or the error of the context
+	Err error
+}
+
+func newCommandError(cmd *exec.Cmd, err error, stderr string) *CommandError {
+	args := []string{}
+	if len(cmd.Args) > 1 {
+		args = redactArgs(cmd.Args[1:])
+	}
+	exitCode := -1
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+	return &CommandError{
+		Command:  subcommand(args),
+		Args:     args,
+		ExitCode: exitCode,
+		Stderr:   stderr,
+		Err:      err,
+	}
+}
+
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("terraform %s failed: %s", e.Command, e.Err)
+	if summary := e.summary(); summary != "" {
+		msg += ": " + summary
+	}
+	return msg
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// summary returns the first error reported by terraform
+func (e *CommandError) summary() string {
+	for _, d := range e.Diagnostics {
+		if d.Severity == "error" {
+			return d.Summary
+		}
+	}
+	for _, line := range strings.Split(e.Stderr, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Error: ") {
+			return strings.TrimPrefix(line, "Error: ")
+		}
+	}
+	return ""
+}
+
+// contains returns true if stderr or the diagnostics contain one of the given messages (case insensitive)
+func (e *CommandError) contains(messages ...string) bool {
+	texts := []string{e.Stderr}
+	for _, d := range e.Diagnostics {
+		texts = append(texts, d.Summary, d.Detail)
+	}
+	for _, text := range texts {
+		if containsAny(text, messages...) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsAny returns true if the text contains one of the messages (case insensitive)
+func containsAny(text string, messages ...string) bool {
+	text = strings.ToLower(text)
+	for _, msg := range messages {
+		if strings.Contains(text, strings.ToLower(msg)) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStateLockError returns true if terraform failed to acquire the state lock.
+func IsStateLockError(err error) bool {
+	return errorContains(err,
+		"Error acquiring the state lock",
+		"Error locking state",
+		"state blob is already locked",
+	)
+}
+
+// providerConfigPattern matches the context terraform reports for errors of a provider configuration,
+// e.g. 'error configuring Terraform AWS Provider' or 'with provider["registry.terraform.io/hashicorp/aws"]'
+var providerConfigPattern = regexp.MustCompile(`(?i)configuring [^\n]*provider|provider\["[^"]+"\]`)
+
+// IsProviderAuthError returns true if a provider failed to authenticate.
+// Authentication failures of the registry or the backend are not provider errors.
+func IsProviderAuthError(err error) bool {
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	for _, text := range cmdErr.errorTexts() {
+		if providerConfigPattern.MatchString(text) && containsAny(text,
+			"No valid credential sources found",
+			"InvalidClientTokenId",
+			"ExpiredToken",
+			"could not find default credentials",
+			"AuthorizationFailed",
+			"authentication failed",
+			"invalid credentials",
+			"401 Unauthorized",
+			"403 Forbidden",
+		) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorTexts returns each error of stderr and the diagnostics separately,
+// so that messages of different errors are not combined
+func (e *CommandError) errorTexts() []string {
+	texts := []string{}
+	for _, d := range e.Diagnostics {
+		if d.Severity == "error" {
+			texts = append(texts, d.Summary+"\n"+d.Detail+"\n"+d.Address)
+		}
+	}
+	for _, block := range strings.Split(e.Stderr, "Error: ") {
+		if strings.TrimSpace(block) != "" {
+			texts = append(texts, block)
+		}
+	}
+	return texts
+}
+
+// IsBackendInitRequired returns true if 'terraform init' must be run before the command.
+func IsBackendInitRequired(err error) bool {
+	return errorContains(err,
+		"Backend initialization required",
+		"Backend configuration changed",
+	)
+}
+
+func errorContains(err error, messages ...string) bool {
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	return cmdErr.contains(messages...)
+}
+
+// redactArgs replaces the values of variables and backend configurations
+func redactArgs(args []string) []string {
+	redactedArgs := make([]string, len(args))
+	for i, arg := range args {
+		redactedArgs[i] = arg
+		if i == 0 {
+			continue
+		}
+		switch args[i-1] {
+		case "-var", "-backend-config":
+			if key := strings.SplitN(arg, "=", 2); len(key) == 2 {
+				redactedArgs[i] = key[0] + "=" + redacted
+			}
+		}
+	}
+	return redactedArgs
+}
+
+// subcommand returns the terraform subcommand of the arguments
+func subcommand(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	switch args[0] {
+	case "state", "workspace", "providers":
+		if len(args) > 1 && !strings.HasPrefix(args[1], "-") {
+			return args[0] + " " + args[1]
+		}
+	}
+	return args[0]
+}
+
+// StalePlanError is returned if a saved plan cannot be applied anymore,
+// because the state changed after the plan was created.
+type StalePlanError struct {
+	PlanFile string
+	Err      error
+}
+
+func (e *StalePlanError) Error() string {
+	return fmt.Sprintf("saved plan '%s' is stale: %s", e.PlanFile, e.Err)
+}
+
+func (e *StalePlanError) Unwrap() error {
+	return e.Err
+}
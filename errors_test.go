@@ -0,0 +1,112 @@
+package tfcli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandError(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+echo "some output" >&2
+echo "Error: Error acquiring the state lock" >&2
+exit 1
+`)
+	stderr := &bytes.Buffer{}
+	tf := New(tfbin, t.TempDir())
+	tf.SetStderr(stderr)
+	tf.WithVars(map[string]string{
+		"password": "s3cr3t",
+	})
+
+	err := tf.Apply()
+	var cmdErr *CommandError
+	if !assert.ErrorAs(t, err, &cmdErr) {
+		assert.FailNow(t, "apply must fail with command error")
+	}
+	assert.Equal(t, "apply", cmdErr.Command)
+	assert.Equal(t, 1, cmdErr.ExitCode)
+	assert.Contains(t, cmdErr.Args, "password="+redacted)
+	assert.NotContains(t, strings.Join(cmdErr.Args, " "), "s3cr3t")
+	assert.Contains(t, cmdErr.Stderr, "some output")
+	assert.Contains(t, stderr.String(), "some output")
+	assert.Contains(t, err.Error(), "Error acquiring the state lock")
+	assert.True(t, IsStateLockError(err))
+	assert.False(t, IsProviderAuthError(err))
+	assert.False(t, IsBackendInitRequired(err))
+}
+
+func TestCommandErrorDiagnostics(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+echo '{"@level":"error","@message":"Error: Backend initialization required","type":"diagnostic","diagnostic":{"severity":"error","summary":"Backend initialization required, please run \"terraform init\"","detail":""}}'
+exit 1
+`)
+	tf := New(tfbin, t.TempDir())
+	tf.SetEventHandler(func(event Event) {})
+
+	err := tf.Plan("")
+	var cmdErr *CommandError
+	if assert.ErrorAs(t, err, &cmdErr) {
+		assert.Len(t, cmdErr.Diagnostics, 1)
+	}
+	assert.True(t, IsBackendInitRequired(err))
+}
+
+func TestCommandErrorContext(t *testing.T) {
+	tf := New(fakeTerraform(t, "sleep 1"), t.TempDir())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := tf.InitContext(ctx)
+	var cmdErr *CommandError
+	if assert.ErrorAs(t, err, &cmdErr) {
+		assert.Equal(t, -1, cmdErr.ExitCode)
+	}
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestSentinelErrors(t *testing.T) {
+	assert.False(t, IsStateLockError(nil))
+	assert.False(t, IsStateLockError(errors.New("Error acquiring the state lock")))
+	authErr := &CommandError{Stderr: "Error: error configuring Terraform AWS Provider: no valid credential sources found"}
+	assert.True(t, IsProviderAuthError(authErr))
+	diagErr := &CommandError{Diagnostics: []Diagnostic{{
+		Severity: "error",
+		Summary:  "Invalid provider configuration",
+		Detail:   "401 Unauthorized",
+		Address:  `provider["registry.terraform.io/hashicorp/google"]`,
+	}}}
+	assert.True(t, IsProviderAuthError(diagErr))
+	backendErr := &CommandError{Stderr: "Error: error configuring S3 Backend: InvalidClientTokenId: 403 Forbidden"}
+	assert.False(t, IsProviderAuthError(backendErr))
+}
+
+func TestProviderAuthErrorRegistry(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+echo "Error: Failed to query available provider packages" >&2
+echo "" >&2
+echo "Could not retrieve the list of available versions for provider example.com/corp/aws:" >&2
+echo "failed to retrieve authentication checksums for provider: 401 Unauthorized" >&2
+exit 1
+`)
+	tf := New(tfbin, t.TempDir())
+
+	err := tf.Init()
+	var cmdErr *CommandError
+	assert.ErrorAs(t, err, &cmdErr)
+	assert.False(t, IsProviderAuthError(err))
+}
+
+func TestRedactArgs(t *testing.T) {
+	args := redactArgs([]string{"init", "-backend-config", "key=value", "-backend-config", "backend.hcl", "-var", "a=b=c"})
+	assert.Equal(t, []string{"init", "-backend-config", "key=" + redacted, "-backend-config", "backend.hcl", "-var", "a=" + redacted}, args)
+}
+
+func TestSubcommand(t *testing.T) {
+	assert.Equal(t, "apply", subcommand([]string{"apply", "-no-color"}))
+	assert.Equal(t, "state mv", subcommand([]string{"state", "mv", "a", "b"}))
+	assert.Equal(t, "", subcommand([]string{}))
+}
@@ -0,0 +1,173 @@
+package tfcli
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EventType is the type of a machine readable UI event.
+// See https://www.terraform.io/internals/machine-readable-ui
+type EventType string
+
+// Machine readable UI event types
+const (
+	EventVersion         EventType = "version"
+	EventLog             EventType = "log"
+	EventDiagnostic      EventType = "diagnostic"
+	EventPlannedChange   EventType = "planned_change"
+	EventChangeSummary   EventType = "change_summary"
+	EventResourceDrift   EventType = "resource_drift"
+	EventOutputs         EventType = "outputs"
+	EventApplyStart      EventType = "apply_start"
+	EventApplyProgress   EventType = "apply_progress"
+	EventApplyComplete   EventType = "apply_complete"
+	EventApplyErrored    EventType = "apply_errored"
+	EventRefreshStart    EventType = "refresh_start"
+	EventRefreshComplete EventType = "refresh_complete"
+)
+
+// EventHandler is called for every machine readable UI event of plan, apply and destroy.
+type EventHandler func(event Event)
+
+// Event is a single message of the terraform machine readable UI.
+type Event struct {
+	Level      string                 `json:"@level"`
+	Message    string                 `json:"@message"`
+	Module     string                 `json:"@module"`
+	Timestamp  time.Time              `json:"@timestamp"`
+	Type       EventType              `json:"type"`
+	Hook       *HookEvent             `json:"hook,omitempty"`
+	Change     *ChangeEvent           `json:"change,omitempty"`
+	Changes    *ChangeSummary         `json:"changes,omitempty"`
+	Diagnostic *Diagnostic            `json:"diagnostic,omitempty"`
+	Outputs    map[string]OutputEvent `json:"outputs,omitempty"`
+}
+
+// ResourceAddr identifies the resource of an event
+type ResourceAddr struct {
+	Addr            string          `json:"addr"`
+	Module          string          `json:"module"`
+	Resource        string          `json:"resource"`
+	ImpliedProvider string          `json:"implied_provider"`
+	ResourceType    string          `json:"resource_type"`
+	ResourceName    string          `json:"resource_name"`
+	ResourceKey     json.RawMessage `json:"resource_key"`
+}
+
+// HookEvent is the payload of apply and refresh events
+type HookEvent struct {
+	Resource       ResourceAddr `json:"resource"`
+	Action         string       `json:"action"`
+	IDKey          string       `json:"id_key"`
+	IDValue        string       `json:"id_value"`
+	ElapsedSeconds float64      `json:"elapsed_seconds"`
+}
+
+// ChangeEvent is the payload of planned change and resource drift events
+type ChangeEvent struct {
+	Resource         ResourceAddr  `json:"resource"`
+	PreviousResource *ResourceAddr `json:"previous_resource,omitempty"`
+	Action           string        `json:"action"`
+	Reason           string        `json:"reason"`
+}
+
+// ChangeSummary is the payload of change summary events
+type ChangeSummary struct {
+	Add       int    `json:"add"`
+	Change    int    `json:"change"`
+	Import    int    `json:"import"`
+	Remove    int    `json:"remove"`
+	Operation string `json:"operation"`
+}
+
+// OutputEvent is a single output of the outputs event
+type OutputEvent struct {
+	Sensitive bool            `json:"sensitive"`
+	Type      json.RawMessage `json:"type"`
+	Value     json.RawMessage `json:"value"`
+	Action    string          `json:"action"`
+}
+
+// Diagnostic is an error or warning reported by terraform
+type Diagnostic struct {
+	Severity string           `json:"severity"`
+	Summary  string           `json:"summary"`
+	Detail   string           `json:"detail"`
+	Address  string           `json:"address,omitempty"`
+	Range    *DiagnosticRange `json:"range,omitempty"`
+}
+
+// DiagnosticRange is the source location of a diagnostic
+type DiagnosticRange struct {
+	Filename string         `json:"filename"`
+	Start    SourcePosition `json:"start"`
+	End      SourcePosition `json:"end"`
+}
+
+// SourcePosition is a position within a source file
+type SourcePosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Byte   int `json:"byte"`
+}
+
+// eventWriter splits the written output into lines and passes
+// every line as event to the handler. It is safe to close while the command is still writing.
+type eventWriter struct {
+	mu      sync.Mutex
+	handler EventHandler
+	buffer  bytes.Buffer
+	closed  bool
+}
+
+func newEventWriter(handler EventHandler) *eventWriter {
+	return &eventWriter{handler: handler}
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return len(p), nil
+	}
+	w.buffer.Write(p)
+	for {
+		i := bytes.IndexByte(w.buffer.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buffer.Next(i + 1)
+		w.handle(line)
+	}
+	return len(p), nil
+}
+
+// Close passes the remaining output to the handler. The handler is not called anymore afterwards,
+// output of processes which survived a kill is ignored.
+func (w *eventWriter) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.buffer.Len() > 0 {
+		w.handle(w.buffer.Bytes())
+		w.buffer.Reset()
+	}
+}
+
+func (w *eventWriter) handle(line []byte) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+	event := Event{}
+	err := json.Unmarshal(line, &event)
+	if err != nil {
+		logrus.Debugf("Ignoring invalid terraform event '%s': %s", line, err)
+		return
+	}
+	w.handler(event)
+}
@@ -0,0 +1,92 @@
+package tfcli
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const recordedApplyEvents = `{"@level":"info","@message":"Terraform 1.1.6","@module":"terraform.ui","@timestamp":"2022-03-10T10:00:00.000000+01:00","terraform":"1.1.6","type":"version","ui":"1.0"}
+{"@level":"info","@message":"null_resource.test: Drift detected (update)","@module":"terraform.ui","@timestamp":"2022-03-10T10:00:00.100000+01:00","change":{"resource":{"addr":"null_resource.test","module":"","resource":"null_resource.test","implied_provider":"null","resource_type":"null_resource","resource_name":"test","resource_key":null},"action":"update"},"type":"resource_drift"}
+{"@level":"info","@message":"null_resource.test: Plan to create","@module":"terraform.ui","@timestamp":"2022-03-10T10:00:00.200000+01:00","change":{"resource":{"addr":"null_resource.test","module":"","resource":"null_resource.test","implied_provider":"null","resource_type":"null_resource","resource_name":"test","resource_key":null},"action":"create"},"type":"planned_change"}
+{"@level":"info","@message":"null_resource.test: Creating...","@module":"terraform.ui","@timestamp":"2022-03-10T10:00:00.300000+01:00","hook":{"resource":{"addr":"null_resource.test","module":"","resource":"null_resource.test","implied_provider":"null","resource_type":"null_resource","resource_name":"test","resource_key":null},"action":"create"},"type":"apply_start"}
+{"@level":"info","@message":"null_resource.test: Still creating... [10s elapsed]","@module":"terraform.ui","@timestamp":"2022-03-10T10:00:10.300000+01:00","hook":{"resource":{"addr":"null_resource.test","module":"","resource":"null_resource.test","implied_provider":"null","resource_type":"null_resource","resource_name":"test","resource_key":null},"action":"create","elapsed_seconds":10},"type":"apply_progress"}
+{"@level":"info","@message":"null_resource.test: Creation complete after 10s [id=1234]","@module":"terraform.ui","@timestamp":"2022-03-10T10:00:10.400000+01:00","hook":{"resource":{"addr":"null_resource.test","module":"","resource":"null_resource.test","implied_provider":"null","resource_type":"null_resource","resource_name":"test","resource_key":null},"action":"create","id_key":"id","id_value":"1234","elapsed_seconds":10},"type":"apply_complete"}
+{"@level":"warn","@message":"Warning: Deprecated attribute","@module":"terraform.ui","@timestamp":"2022-03-10T10:00:10.500000+01:00","diagnostic":{"severity":"warning","summary":"Deprecated attribute","detail":"The attribute is deprecated.","range":{"filename":"main.tf","start":{"line":3,"column":5,"byte":40},"end":{"line":3,"column":12,"byte":47}}},"type":"diagnostic"}
+{"@level":"info","@message":"Apply complete! Resources: 1 added, 0 changed, 0 destroyed.","@module":"terraform.ui","@timestamp":"2022-03-10T10:00:10.600000+01:00","changes":{"add":1,"change":0,"remove":0,"operation":"apply"},"type":"change_summary"}
+{"@level":"info","@message":"Outputs: 1","@module":"terraform.ui","@timestamp":"2022-03-10T10:00:10.700000+01:00","outputs":{"myvar":{"sensitive":false,"type":"string","value":"var_value"}},"type":"outputs"}`
+
+func TestEventWriter(t *testing.T) {
+	events := []Event{}
+	w := newEventWriter(func(event Event) {
+		events = append(events, event)
+	})
+	// write in small chunks to make sure lines are reassembled
+	raw := []byte(recordedApplyEvents + "\nnot json\n")
+	for len(raw) > 0 {
+		n := 13
+		if n > len(raw) {
+			n = len(raw)
+		}
+		_, err := w.Write(raw[:n])
+		must(t, err)
+		raw = raw[n:]
+	}
+	w.Close()
+	// output written after close is ignored
+	_, err := w.Write([]byte(recordedApplyEvents + "\n"))
+	must(t, err)
+
+	if !assert.Len(t, events, 9) {
+		assert.FailNow(t, "unexpected number of events")
+	}
+	assert.Equal(t, EventVersion, events[0].Type)
+	assert.Equal(t, EventResourceDrift, events[1].Type)
+	assert.Equal(t, "update", events[1].Change.Action)
+	assert.Equal(t, EventApplyProgress, events[4].Type)
+	assert.Equal(t, float64(10), events[4].Hook.ElapsedSeconds)
+	assert.Equal(t, "1234", events[5].Hook.IDValue)
+	assert.Equal(t, "null_resource.test", events[5].Hook.Resource.Addr)
+	assert.Equal(t, "warning", events[6].Diagnostic.Severity)
+	assert.Equal(t, 3, events[6].Diagnostic.Range.Start.Line)
+	assert.Equal(t, 1, events[7].Changes.Add)
+	assert.Equal(t, `"var_value"`, string(events[8].Outputs["myvar"].Value))
+}
+
+func TestEventHandler(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+echo "$@" >&2
+cat <<'EOF'
+`+recordedApplyEvents+`
+EOF
+`)
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	tf := New(tfbin, t.TempDir())
+	tf.SetStdout(stdout)
+	tf.SetStderr(stderr)
+
+	types := []EventType{}
+	tf.SetEventHandler(func(event Event) {
+		types = append(types, event.Type)
+	})
+	err := tf.Apply()
+	if !assert.NoError(t, err) {
+		logBuffer(t, stderr)
+		assert.FailNow(t, "apply failed")
+	}
+	assert.Contains(t, strings.Fields(stderr.String()), "-json")
+	assert.Equal(t, recordedApplyEvents+"\n", stdout.String())
+	assert.Equal(t, []EventType{
+		EventVersion, EventResourceDrift, EventPlannedChange, EventApplyStart, EventApplyProgress,
+		EventApplyComplete, EventDiagnostic, EventChangeSummary, EventOutputs,
+	}, types)
+
+	stderr.Reset()
+	tf.SetEventHandler(nil)
+	err = tf.Destroy()
+	assert.NoError(t, err)
+	assert.NotContains(t, strings.Fields(stderr.String()), "-json")
+}
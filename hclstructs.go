@@ -2,11 +2,16 @@ package tfcli
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 
+	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
 )
 
 type tfjson struct {
@@ -15,13 +20,17 @@ type tfjson struct {
 
 type tfmodule struct {
 	Source  string `json:"source"`
-	Version string `json:"version"`
+	Version string `json:"version,omitempty"`
 }
 
 // Terraform CLI configuration:
 // https://www.terraform.io/cli/config/config-file
 type tfconfig struct {
-	Credentials []RegistryCredential `hcl:"credentials,block"`
+	PluginCacheDir    *string              `hcl:"plugin_cache_dir,optional"`
+	DisableCheckpoint *bool                `hcl:"disable_checkpoint,optional"`
+	Credentials       []RegistryCredential `hcl:"credentials,block"`
+	CredentialsHelper *CredentialsHelper   `hcl:"credentials_helper,block"`
+	Hosts             []ServiceHost        `hcl:"host,block"`
 }
 
 // RegistryCredential defines a registry credential pair
@@ -30,11 +39,58 @@ type RegistryCredential struct {
 	Token string `hcl:"token"`
 }
 
-// writeTerraformRC writes the terraform cli configuration file to given to filepath
-func writeHcl(filepath string, val interface{}) error {
-	out := hclwrite.NewEmptyFile()
-	gohcl.EncodeIntoBody(val, out.Body())
-	f, err := os.Create(filepath)
+// ServiceHost overrides the service discovery of a host, e.g. to use a private registry without discovery document.
+// See https://www.terraform.io/cli/config/config-file#host-blocks
+type ServiceHost struct {
+	Hostname string `hcl:"hostname,label"`
+	// Services maps service ids like 'modules.v1' to their base URL
+	Services map[string]string `hcl:"services"`
+}
+
+// Provider installation methods
+const (
+	InstallFilesystemMirror = "filesystem_mirror"
+	InstallNetworkMirror    = "network_mirror"
+	InstallDirect           = "direct"
+)
+
+// ProviderInstallationMethod is a method terraform uses to install providers.
+// See https://www.terraform.io/cli/config/config-file#provider-installation
+type ProviderInstallationMethod struct {
+	// Type is one of InstallFilesystemMirror, InstallNetworkMirror or InstallDirect
+	Type string
+	// Path is the directory of a filesystem mirror
+	Path string
+	// URL is the base URL of a network mirror
+	URL string
+	// Include and Exclude are provider source patterns like 'example.com/*/*'
+	Include []string
+	Exclude []string
+}
+
+// CLIConfig are the settings of the generated terraform CLI configuration file
+type CLIConfig struct {
+	// PluginCacheDir shares downloaded providers between working directories. The directory is created if missing.
+	PluginCacheDir string
+	// DisableCheckpoint disables the upgrade and security bulletin checks
+	DisableCheckpoint bool
+	// ProviderInstallation are the provider installation methods in the order terraform tries them
+	ProviderInstallation []ProviderInstallationMethod
+	// Hosts override the service discovery of hosts
+	Hosts []ServiceHost
+	// CredentialsHelper is an external program which provides registry credentials
+	CredentialsHelper *CredentialsHelper
+}
+
+// isEmpty returns true if no setting differs from the terraform defaults
+func (c CLIConfig) isEmpty() bool {
+	return c.PluginCacheDir == "" && !c.DisableCheckpoint && len(c.ProviderInstallation) == 0 &&
+		len(c.Hosts) == 0 && c.CredentialsHelper == nil
+}
+
+// writeHclFile writes the file only readable by the owner, it may contain credentials
+func writeHclFile(filepath string, out *hclwrite.File) error {
+	f, err := os.OpenFile(filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
@@ -46,11 +102,53 @@ func writeHcl(filepath string, val interface{}) error {
 	return nil
 }
 
-func writeTerraformConfig(filepath string, credentials []RegistryCredential) error {
+// writeTerraformConfig writes the terraform cli configuration file to the given filepath
+func writeTerraformConfig(filepath string, credentials []RegistryCredential, config CLIConfig) error {
 	tfconfig := tfconfig{
-		Credentials: credentials,
+		Credentials:       credentials,
+		CredentialsHelper: config.CredentialsHelper,
+		Hosts:             config.Hosts,
+	}
+	if config.PluginCacheDir != "" {
+		tfconfig.PluginCacheDir = &config.PluginCacheDir
+	}
+	if config.DisableCheckpoint {
+		tfconfig.DisableCheckpoint = &config.DisableCheckpoint
+	}
+	out := hclwrite.NewEmptyFile()
+	gohcl.EncodeIntoBody(tfconfig, out.Body())
+	// the installation methods are written by hand to keep their order
+	if len(config.ProviderInstallation) > 0 {
+		out.Body().AppendNewline()
+		installation := out.Body().AppendNewBlock("provider_installation", nil).Body()
+		for _, method := range config.ProviderInstallation {
+			block := installation.AppendNewBlock(method.Type, nil).Body()
+			switch method.Type {
+			case InstallFilesystemMirror:
+				block.SetAttributeValue("path", cty.StringVal(method.Path))
+			case InstallNetworkMirror:
+				block.SetAttributeValue("url", cty.StringVal(method.URL))
+			case InstallDirect:
+			default:
+				return fmt.Errorf("unknown provider installation method '%s'", method.Type)
+			}
+			if len(method.Include) > 0 {
+				block.SetAttributeValue("include", stringList(method.Include))
+			}
+			if len(method.Exclude) > 0 {
+				block.SetAttributeValue("exclude", stringList(method.Exclude))
+			}
+		}
+	}
+	return writeHclFile(filepath, out)
+}
+
+func stringList(values []string) cty.Value {
+	list := make([]cty.Value, len(values))
+	for i, v := range values {
+		list[i] = cty.StringVal(v)
 	}
-	return writeHcl(filepath, tfconfig)
+	return cty.ListVal(list)
 }
 
 // writeModuleFile writes a temporary module file
@@ -58,7 +156,7 @@ func writeModuleFile(filename string, packet, version string) error {
 	tfjs := tfjson{
 		Module: map[string]tfmodule{},
 	}
-	tfjs.Module["module"] = tfmodule{
+	tfjs.Module[moduleKey] = tfmodule{
 		Source:  packet,
 		Version: version,
 	}
@@ -68,3 +166,38 @@ func writeModuleFile(filename string, packet, version string) error {
 	}
 	return ioutil.WriteFile(filename, raw, 0644)
 }
+
+// writeVarsFile writes the variables as terraform JSON variable definitions file.
+// The file may contain secrets and is therefore only readable by the owner.
+func writeVarsFile(filename string, vars map[string]interface{}) error {
+	values := make(map[string]interface{}, len(vars))
+	for name, value := range vars {
+		if v, ok := value.(cty.Value); ok {
+			value = ctyjson.SimpleJSONValue{Value: v}
+		}
+		values[name] = value
+	}
+	raw, err := json.MarshalIndent(values, "", " ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, raw, 0600)
+}
+
+// writeImportFile writes an import block for every import spec
+func writeImportFile(filename string, specs []ImportSpec) error {
+	out := hclwrite.NewEmptyFile()
+	for i, spec := range specs {
+		traversal, diags := hclsyntax.ParseTraversalAbs([]byte(spec.Address), filename, hcl.InitialPos)
+		if diags.HasErrors() {
+			return fmt.Errorf("invalid resource address '%s': %s", spec.Address, diags.Error())
+		}
+		if i > 0 {
+			out.Body().AppendNewline()
+		}
+		block := out.Body().AppendNewBlock("import", nil)
+		block.Body().SetAttributeTraversal("to", traversal)
+		block.Body().SetAttributeValue("id", cty.StringVal(spec.ID))
+	}
+	return ioutil.WriteFile(filename, out.Bytes(), 0644)
+}
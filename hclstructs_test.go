@@ -5,9 +5,12 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/hashicorp/hcl/v2/hclsimple"
+	"github.com/zclconf/go-cty/cty"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -25,7 +28,7 @@ func TestWriteTerraformConfig(t *testing.T) {
 		{Type: "hello", Token: "123"},
 	}
 
-	err = writeTerraformConfig(file.Name(), creds)
+	err = writeTerraformConfig(file.Name(), creds, CLIConfig{})
 	assert.NoErrorf(t, err, "writeTerraformConfig must not fail")
 
 	config := &tfconfig{}
@@ -66,3 +69,81 @@ func TestWriteModuleFile(t *testing.T) {
 	assert.Equal(t, "~> 1.0.0", m.Version)
 
 }
+
+func TestWriteVarsFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.auto.tfvars.json")
+	err := writeVarsFile(file, map[string]interface{}{
+		"string": "value",
+		"number": 3,
+		"list":   []string{"a", "b"},
+		"object": map[string]interface{}{"enabled": true},
+		"cty":    cty.ListVal([]cty.Value{cty.StringVal("c")}),
+	})
+	assert.NoErrorf(t, err, "writeVarsFile must not fail")
+
+	raw, err := ioutil.ReadFile(file)
+	assert.NoErrorf(t, err, "cannot read file")
+	assert.JSONEq(t, `{
+		"string": "value",
+		"number": 3,
+		"list": ["a", "b"],
+		"object": {"enabled": true},
+		"cty": ["c"]
+	}`, string(raw))
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(file)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+}
+
+func TestWriteTerraformConfigCLIConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.tfrc")
+	err := writeTerraformConfig(file, []RegistryCredential{{Type: "app.terraform.io", Token: "123"}}, CLIConfig{
+		PluginCacheDir:    "/var/cache/terraform",
+		DisableCheckpoint: true,
+		Hosts: []ServiceHost{
+			{Hostname: "registry.example.com", Services: map[string]string{"modules.v1": "https://registry.example.com/modules/"}},
+		},
+		ProviderInstallation: []ProviderInstallationMethod{
+			{Type: InstallFilesystemMirror, Path: "/usr/share/terraform/providers", Include: []string{"example.com/*/*"}},
+			{Type: InstallNetworkMirror, URL: "https://mirror.example.com/providers/"},
+			{Type: InstallDirect, Exclude: []string{"example.com/*/*"}},
+		},
+	})
+	must(t, err)
+	raw, err := ioutil.ReadFile(file)
+	must(t, err)
+	assert.Equal(t, `plugin_cache_dir   = "/var/cache/terraform"
+disable_checkpoint = true
+
+credentials "app.terraform.io" {
+  token = "123"
+}
+
+host "registry.example.com" {
+  services = {
+    "modules.v1" = "https://registry.example.com/modules/"
+  }
+}
+
+provider_installation {
+  filesystem_mirror {
+    path    = "/usr/share/terraform/providers"
+    include = ["example.com/*/*"]
+  }
+  network_mirror {
+    url = "https://mirror.example.com/providers/"
+  }
+  direct {
+    exclude = ["example.com/*/*"]
+  }
+}
+`, string(raw))
+
+	err = writeTerraformConfig(file, nil, CLIConfig{
+		ProviderInstallation: []ProviderInstallationMethod{{Type: "unknown"}},
+	})
+	assert.Error(t, err)
+}
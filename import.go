@@ -0,0 +1,112 @@
+package tfcli
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/hashicorp/go-version"
+)
+
+// minImportBlockVersion is the first terraform version supporting import blocks
+var minImportBlockVersion = version.Must(version.NewVersion("1.5.0"))
+
+// ImportSpec defines a resource to import
+type ImportSpec struct {
+	// Address is the resource address to import to, e.g. 'aws_instance.web'
+	Address string
+	// ID is the provider specific id of the existing resource
+	ID string
+}
+
+// ImportResult is the result of a single import
+type ImportResult struct {
+	ImportSpec
+	Err error
+}
+
+// ImportReport contains the results of all imports of ImportAll
+type ImportReport struct {
+	Results []ImportResult
+}
+
+// Failed returns the results of all failed imports.
+func (r *ImportReport) Failed() []ImportResult {
+	failed := []ImportResult{}
+	for _, res := range r.Results {
+		if res.Err != nil {
+			failed = append(failed, res)
+		}
+	}
+	return failed
+}
+
+// Succeeded returns the results of all successful imports.
+func (r *ImportReport) Succeeded() []ImportResult {
+	succeeded := []ImportResult{}
+	for _, res := range r.Results {
+		if res.Err == nil {
+			succeeded = append(succeeded, res)
+		}
+	}
+	return succeeded
+}
+
+// Import imports the existing resource with the given id into the state.
+func (t *terraform) Import(address, id string) error {
+	return t.ImportContext(context.Background(), address, id)
+}
+
+func (t *terraform) ImportContext(ctx context.Context, address, id string) error {
+	varFileArgs, cleanup, err := t.writeVarsFile()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	varsArgs := mapToArgs(t.vars, "var")
+	cmd := t.newCommand([]string{"import", "-no-color", "-input=false"}, varFileArgs, varsArgs, []string{address, id})
+	return t.run(ctx, cmd)
+}
+
+// ImportAll imports the resources one after another. The report contains
+// the result of every import. An error is returned if any import failed.
+func (t *terraform) ImportAll(specs []ImportSpec) (*ImportReport, error) {
+	return t.ImportAllContext(context.Background(), specs)
+}
+
+func (t *terraform) ImportAllContext(ctx context.Context, specs []ImportSpec) (*ImportReport, error) {
+	report := &ImportReport{}
+	for _, spec := range specs {
+		// do not start further imports if the context is done
+		err := ctx.Err()
+		if err == nil {
+			err = t.ImportContext(ctx, spec.Address, spec.ID)
+		}
+		report.Results = append(report.Results, ImportResult{ImportSpec: spec, Err: err})
+	}
+	if failed := report.Failed(); len(failed) > 0 {
+		return report, fmt.Errorf("%d of %d imports failed, first error: %w", len(failed), len(specs), failed[0].Err)
+	}
+	return report, nil
+}
+
+// WriteImportBlocks writes import blocks for config-driven import into the
+// given file of the working directory. Requires terraform 1.5 or later.
+func (t *terraform) WriteImportBlocks(filename string, specs []ImportSpec) error {
+	return t.WriteImportBlocksContext(context.Background(), filename, specs)
+}
+
+func (t *terraform) WriteImportBlocksContext(ctx context.Context, filename string, specs []ImportSpec) error {
+	v, err := t.terraformVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if v.LessThan(minImportBlockVersion) {
+		return fmt.Errorf("import blocks require terraform %s or later, got %s", minImportBlockVersion, v)
+	}
+	filename = filepath.FromSlash(filename)
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(t.dir, filename)
+	}
+	return writeImportFile(filename, specs)
+}
@@ -0,0 +1,82 @@
+package tfcli
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func importTerraform(t *testing.T, tfVersion string) (Terraform, string) {
+	return recordingTerraform(t, `
+if [ "$1" = "version" ]; then
+	echo '{"terraform_version": "`+tfVersion+`"}'
+	exit 0
+fi
+for last; do true; done
+if [ "$last" = "missing" ]; then
+	echo "Error: Cannot import non-existent remote object" >&2
+	exit 1
+fi
+`)
+}
+
+func TestImport(t *testing.T) {
+	tf, dir := importTerraform(t, "1.1.6")
+	tf.WithVars(map[string]string{"region": "eu"})
+	must(t, tf.Import("aws_instance.web", "i-1234"))
+	assert.Error(t, tf.Import("aws_instance.web", "missing"))
+	assert.Equal(t, []string{
+		"import -no-color -input=false -var region=eu aws_instance.web i-1234",
+		"import -no-color -input=false -var region=eu aws_instance.web missing",
+	}, readCalls(t, dir))
+}
+
+func TestImportAll(t *testing.T) {
+	tf, _ := importTerraform(t, "1.1.6")
+	report, err := tf.ImportAll([]ImportSpec{
+		{Address: "aws_instance.a", ID: "i-1"},
+		{Address: "aws_instance.b", ID: "missing"},
+		{Address: "aws_instance.c", ID: "i-3"},
+	})
+	assert.Error(t, err)
+	if assert.NotNil(t, report) {
+		assert.Len(t, report.Results, 3)
+		assert.Len(t, report.Succeeded(), 2)
+		failed := report.Failed()
+		if assert.Len(t, failed, 1) {
+			assert.Equal(t, "aws_instance.b", failed[0].Address)
+			assert.Contains(t, failed[0].Err.Error(), "Cannot import non-existent remote object")
+		}
+	}
+
+	report, err = tf.ImportAll([]ImportSpec{{Address: "aws_instance.a", ID: "i-1"}})
+	assert.NoError(t, err)
+	assert.Empty(t, report.Failed())
+}
+
+func TestWriteImportBlocks(t *testing.T) {
+	specs := []ImportSpec{
+		{Address: "aws_instance.web", ID: "i-1234"},
+		{Address: `module.net.aws_subnet.this["a"]`, ID: "subnet-1"},
+	}
+	tf, _ := importTerraform(t, "1.1.6")
+	assert.Error(t, tf.WriteImportBlocks("imports.tf", specs))
+
+	tf, dir := importTerraform(t, "1.5.7")
+	assert.Error(t, tf.WriteImportBlocks("imports.tf", []ImportSpec{{Address: "invalid address!", ID: "1"}}))
+	must(t, tf.WriteImportBlocks("imports.tf", specs))
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "imports.tf"))
+	must(t, err)
+	assert.Equal(t, `import {
+  to = aws_instance.web
+  id = "i-1234"
+}
+
+import {
+  to = module.net.aws_subnet.this["a"]
+  id = "subnet-1"
+}
+`, string(raw))
+}
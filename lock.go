@@ -0,0 +1,112 @@
+package tfcli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// lockRetryInterval is the time between two attempts to acquire a file lock
+const lockRetryInterval = 100 * time.Millisecond
+
+// lockFile acquires an exclusive lock on the file to guard it against other processes.
+// It waits until the lock is acquired or the context is done.
+// The lock file may be removed by its holder, the lock is then acquired on the new file.
+func lockFile(ctx context.Context, path string) (func(), error) {
+	var retry *time.Timer
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, err
+		}
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if locked && isCurrentFile(f, path) {
+			return func() {
+				unlockFile(f)
+				f.Close()
+			}, nil
+		}
+		if locked {
+			unlockFile(f)
+		}
+		f.Close()
+		if retry == nil {
+			retry = time.NewTimer(lockRetryInterval)
+			defer retry.Stop()
+		} else {
+			retry.Reset(lockRetryInterval)
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-retry.C:
+		}
+	}
+}
+
+// isCurrentFile returns true if the open file is still the file at path
+func isCurrentFile(f *os.File, path string) bool {
+	opened, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(opened, current)
+}
+
+// flightGroup deduplicates concurrent calls with the same key within the process
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done   chan struct{}
+	result string
+	err    error
+}
+
+// do runs fn once for all concurrent callers with the same key and shares its result.
+// Callers stop waiting when their context is done. If the running call was canceled
+// by the context of another caller, the waiting callers run fn again.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (string, error)) (string, error) {
+	for {
+		g.mu.Lock()
+		if g.calls == nil {
+			g.calls = map[string]*flight{}
+		}
+		f, ok := g.calls[key]
+		if !ok {
+			f = &flight{done: make(chan struct{})}
+			g.calls[key] = f
+			g.mu.Unlock()
+
+			f.result, f.err = fn()
+			g.mu.Lock()
+			delete(g.calls, key)
+			g.mu.Unlock()
+			close(f.done)
+			return f.result, f.err
+		}
+		g.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-f.done:
+		}
+		if errors.Is(f.err, context.Canceled) || errors.Is(f.err, context.DeadlineExceeded) {
+			continue
+		}
+		return f.result, f.err
+	}
+}
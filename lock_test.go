@@ -0,0 +1,135 @@
+package tfcli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lock")
+	unlock, err := lockFile(context.Background(), path)
+	must(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*lockRetryInterval)
+	defer cancel()
+	_, err = lockFile(ctx, path)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	unlock()
+	unlock, err = lockFile(context.Background(), path)
+	if assert.NoError(t, err) {
+		unlock()
+	}
+}
+
+func TestLockFileRemoved(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("open files cannot be removed on windows")
+	}
+	path := filepath.Join(t.TempDir(), "test.lock")
+	unlock, err := lockFile(context.Background(), path)
+	must(t, err)
+
+	locked := make(chan func(), 1)
+	go func() {
+		unlockWaiter, err := lockFile(context.Background(), path)
+		assert.NoError(t, err)
+		locked <- unlockWaiter
+	}()
+	time.Sleep(2 * lockRetryInterval)
+	// the holder removes the lock file before it releases the lock
+	must(t, os.Remove(path))
+	unlock()
+	unlockWaiter := <-locked
+	defer unlockWaiter()
+	assert.FileExists(t, path)
+
+	// the lock is held on the new file
+	ctx, cancel := context.WithTimeout(context.Background(), 3*lockRetryInterval)
+	defer cancel()
+	_, err = lockFile(ctx, path)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestFlightGroup(t *testing.T) {
+	g := &flightGroup{}
+	var calls int32
+	release := make(chan struct{})
+	wg := sync.WaitGroup{}
+	results := make([]string, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = g.do(context.Background(), "key", func() (string, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "done", nil
+			})
+		}(i)
+	}
+	// give all goroutines the chance to join the running call
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, result := range results {
+		assert.Equal(t, "done", result)
+	}
+
+	result, err := g.do(context.Background(), "key", func() (string, error) {
+		return "again", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "again", result)
+}
+
+func TestFlightGroupContext(t *testing.T) {
+	g := &flightGroup{}
+	release := make(chan struct{})
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderDone := make(chan error, 1)
+	go func() {
+		_, err := g.do(leaderCtx, "key", func() (string, error) {
+			select {
+			case <-release:
+				return "leader", nil
+			case <-leaderCtx.Done():
+				return "", leaderCtx.Err()
+			}
+		})
+		leaderDone <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// a waiter stops waiting when its own context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := g.do(ctx, "key", func() (string, error) {
+		return "waiter", nil
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// a waiter does not inherit the cancellation of the leader
+	waiterDone := make(chan string, 1)
+	go func() {
+		result, _ := g.do(context.Background(), "key", func() (string, error) {
+			return "waiter", nil
+		})
+		waiterDone <- result
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancelLeader()
+	assert.ErrorIs(t, <-leaderDone, context.Canceled)
+	assert.Equal(t, "waiter", <-waiterDone)
+	close(release)
+}
@@ -0,0 +1,177 @@
+package tfcli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	getter "github.com/hashicorp/go-getter"
+	"github.com/sirupsen/logrus"
+)
+
+// moduleKey is the name of the module call GetModule generates
+const moduleKey = "module"
+
+// ModuleResult describes the module fetched by GetModule
+type ModuleResult struct {
+	// Source is the source address as resolved by terraform, e.g. 'registry.terraform.io/org/name/provider'
+	Source string
+	// Version is the selected version of a registry module, empty for other sources
+	Version string
+	// Dir is the directory the module was installed into.
+	// GetModule returns the directory terraform installed the module into, relative to the working directory.
+	Dir string
+}
+
+// moduleManifest is the module installation record in '.terraform/modules/modules.json'
+type moduleManifest struct {
+	Modules []struct {
+		Key     string `json:"Key"`
+		Source  string `json:"Source"`
+		Version string `json:"Version"`
+		Dir     string `json:"Dir"`
+	} `json:"Modules"`
+}
+
+// readModuleManifest returns the installed module with the given key
+func readModuleManifest(dir, key string) (*ModuleResult, error) {
+	file := filepath.Join(dir, ".terraform", "modules", "modules.json")
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read module manifest: %s", err)
+	}
+	manifest := &moduleManifest{}
+	err = json.Unmarshal(raw, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read module manifest '%s': %s", file, err)
+	}
+	for _, m := range manifest.Modules {
+		if m.Key == key {
+			return &ModuleResult{
+				Source:  m.Source,
+				Version: m.Version,
+				Dir:     filepath.FromSlash(m.Dir),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("module '%s' not found in module manifest '%s'", key, file)
+}
+
+// FetchModule downloads a module into dst without terraform.
+// Registry addresses like 'hashicorp/consul/aws' are resolved with the module registry protocol
+// and the newest version matching the version constraint is fetched. The credentials are used
+// for the registry with the same hostname, other registries get the TF_TOKEN_<host> environment variable.
+// All other sources are downloaded with go-getter and must not have a version constraint.
+// Use a RegistryClient to fetch modules with a CredentialsSource.
+func FetchModule(ctx context.Context, source, version, dst string, credentials ...RegistryCredential) (*ModuleResult, error) {
+	return fetchModule(ctx, newRegistryClient(http.DefaultClient, credentials), source, version, dst)
+}
+
+func fetchModule(ctx context.Context, registry *registryClient, source, version, dst string) (*ModuleResult, error) {
+	result := &ModuleResult{Source: source, Dir: dst}
+	getterSource := source
+	if addr, ok := parseModuleAddress(source); ok {
+		v, err := registry.resolveVersion(ctx, addr, version)
+		if err != nil {
+			return nil, err
+		}
+		location, err := registry.downloadSource(ctx, addr, v)
+		if err != nil {
+			return nil, err
+		}
+		getterSource = joinSubdir(location, addr.Subdir)
+		result.Source = addr.String()
+		result.Version = v
+	} else if version != "" {
+		return nil, fmt.Errorf("module '%s' is not a registry module and cannot have a version constraint", source)
+	}
+
+	// go-getter links local directories, but the module must be a copy
+	if isLocalSource(source) {
+		err := copyDir(source, dst)
+		if err != nil {
+			return nil, fmt.Errorf("cannot fetch module '%s': %s", source, err)
+		}
+		return result, nil
+	}
+
+	pwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	logrus.Debugf("Fetch module '%s' from '%s'", source, getterSource)
+	client := &getter.Client{
+		Ctx:     ctx,
+		Src:     getterSource,
+		Dst:     dst,
+		Pwd:     pwd,
+		Mode:    getter.ClientModeDir,
+		Getters: moduleGetters(registry.client),
+	}
+	err = client.Get()
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch module '%s': %s", source, err)
+	}
+	return result, nil
+}
+
+// isLocalSource returns true if the module source is a local path
+func isLocalSource(source string) bool {
+	return strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../") || filepath.IsAbs(source) ||
+		strings.HasPrefix(source, ".\\") || strings.HasPrefix(source, "..\\")
+}
+
+// isLocalLocation returns true if the go-getter source refers to the local file system,
+// e.g. a local path, a 'file://' URL or a source forced to the 'file' getter
+func isLocalLocation(location string) bool {
+	if isLocalSource(location) || strings.HasPrefix(location, "/") || strings.HasPrefix(location, "\\") {
+		return true
+	}
+	forced, rest := "", location
+	if i := strings.Index(location, "::"); i > -1 {
+		forced, rest = location[:i], location[i+2:]
+	}
+	if strings.EqualFold(forced, "file") {
+		return true
+	}
+	u, err := url.Parse(rest)
+	if err != nil {
+		return false
+	}
+	// single letter schemes are windows drive letters
+	return strings.EqualFold(u.Scheme, "file") || len(u.Scheme) == 1
+}
+
+// moduleGetters returns the go-getter getters using the HTTP client
+func moduleGetters(client *http.Client) map[string]getter.Getter {
+	getters := make(map[string]getter.Getter, len(getter.Getters))
+	for k, g := range getter.Getters {
+		getters[k] = g
+	}
+	httpGetter := &getter.HttpGetter{Client: client, Netrc: true}
+	getters["http"] = httpGetter
+	getters["https"] = httpGetter
+	return getters
+}
+
+// joinSubdir adds the subdirectory to a go-getter source, keeping its query at the end
+func joinSubdir(source, subdir string) string {
+	if subdir == "" {
+		return source
+	}
+	base, existing := getter.SourceDirSubdir(source)
+	if existing != "" {
+		subdir = strings.TrimSuffix(existing, "/") + "/" + subdir
+	}
+	query := ""
+	if i := strings.Index(base, "?"); i > -1 {
+		base, query = base[:i], base[i:]
+	}
+	return base + "//" + subdir + query
+}
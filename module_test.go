@@ -0,0 +1,180 @@
+package tfcli
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// moduleTerraform returns a fake terraform which installs a module like 'terraform get'
+// and records the generated module file as module.tf.json.
+func moduleTerraform(t *testing.T, manifest string, files ...string) (Terraform, string) {
+	script := `
+cp main.tf.json module.tf.json
+mkdir -p .terraform/modules
+cat > .terraform/modules/modules.json <<'JSON'
+` + manifest + `
+JSON
+`
+	for _, file := range files {
+		script += "mkdir -p $(dirname " + file + ") && echo 'content' > " + file + "\n"
+	}
+	return recordingTerraform(t, script)
+}
+
+func readModuleFile(t *testing.T, dir string) tfmodule {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "module.tf.json"))
+	must(t, err)
+	module := map[string]map[string]tfmodule{}
+	must(t, json.Unmarshal(raw, &module))
+	return module["module"][moduleKey]
+}
+
+func TestGetModuleRegistry(t *testing.T) {
+	tf, dir := moduleTerraform(t, `{"Modules": [
+	{"Key": "", "Source": "", "Dir": "."},
+	{"Key": "module", "Source": "registry.terraform.io/weakpixel/test-module/tfcli", "Version": "0.0.2", "Dir": ".terraform/modules/module"}
+]}`, ".terraform/modules/module/main.tf")
+
+	result, err := tf.GetModuleWithResult("weakpixel/test-module/tfcli", "~> 0.0.1")
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "get module failed")
+	}
+	assert.Equal(t, &ModuleResult{
+		Source:  "registry.terraform.io/weakpixel/test-module/tfcli",
+		Version: "0.0.2",
+		Dir:     filepath.Join(".terraform", "modules", "module"),
+	}, result)
+	assert.FileExists(t, filepath.Join(dir, "main.tf"))
+	assert.Equal(t, tfmodule{Source: "weakpixel/test-module/tfcli", Version: "~> 0.0.1"}, readModuleFile(t, dir))
+}
+
+func TestGetModuleSubdir(t *testing.T) {
+	tf, dir := moduleTerraform(t, `{"Modules": [
+	{"Key": "", "Source": "", "Dir": "."},
+	{"Key": "module", "Source": "git::https://example.com/modules.git//network?ref=v1.0.0", "Dir": ".terraform/modules/module/network"}
+]}`, ".terraform/modules/module/network/main.tf", ".terraform/modules/module/compute/main.tf")
+
+	result, err := tf.GetModuleWithResult("git::https://example.com/modules.git//network?ref=v1.0.0", "")
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "get module failed")
+	}
+	assert.Equal(t, "git::https://example.com/modules.git//network?ref=v1.0.0", result.Source)
+	assert.Empty(t, result.Version)
+	assert.FileExists(t, filepath.Join(dir, "main.tf"))
+	assert.NoDirExists(t, filepath.Join(dir, "compute"))
+	// no version constraint for non registry sources
+	assert.Equal(t, tfmodule{Source: "git::https://example.com/modules.git//network?ref=v1.0.0"}, readModuleFile(t, dir))
+}
+
+func TestGetModuleLocal(t *testing.T) {
+	src := t.TempDir()
+	must(t, os.MkdirAll(filepath.Join(src, "templates"), 0755))
+	must(t, os.MkdirAll(filepath.Join(src, ".terraform"), 0755))
+	must(t, ioutil.WriteFile(filepath.Join(src, "main.tf"), []byte(`resource "null_resource" "test" {}`), 0644))
+	must(t, ioutil.WriteFile(filepath.Join(src, "templates", "user_data.sh"), []byte("#!/bin/sh"), 0755))
+
+	dir := t.TempDir()
+	rel, err := filepath.Rel(dir, src)
+	must(t, err)
+	tfbin := fakeTerraform(t, `
+mkdir -p .terraform/modules
+cat > .terraform/modules/modules.json <<'JSON'
+{"Modules": [{"Key": "", "Source": "", "Dir": "."}, {"Key": "module", "Source": "`+filepath.ToSlash(rel)+`", "Dir": "`+filepath.ToSlash(rel)+`"}]}
+JSON
+`)
+	result, err := New(tfbin, dir).GetModuleWithResult(rel, "")
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "get module failed")
+	}
+	assert.Equal(t, rel, result.Dir)
+	assert.FileExists(t, filepath.Join(dir, "main.tf"))
+	assert.FileExists(t, filepath.Join(dir, "templates", "user_data.sh"))
+	info, err := os.Stat(filepath.Join(dir, "templates", "user_data.sh"))
+	must(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	// the local source is left untouched
+	assert.FileExists(t, filepath.Join(src, "main.tf"))
+}
+
+func TestGetModuleLocalAncestor(t *testing.T) {
+	src := t.TempDir()
+	must(t, ioutil.WriteFile(filepath.Join(src, "main.tf"), []byte(`resource "null_resource" "test" {}`), 0644))
+	dir := filepath.Join(src, "work")
+	must(t, os.MkdirAll(dir, 0755))
+	tfbin := fakeTerraform(t, `
+mkdir -p .terraform/modules
+cat > .terraform/modules/modules.json <<'JSON'
+{"Modules": [{"Key": "", "Source": "", "Dir": "."}, {"Key": "module", "Source": "../", "Dir": ".."}]}
+JSON
+`)
+	_, err := New(tfbin, dir).GetModuleWithResult("../", "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "contains the working directory")
+	}
+	assert.NoFileExists(t, filepath.Join(dir, "main.tf"))
+}
+
+func TestGetModuleMissingManifestEntry(t *testing.T) {
+	tf, _ := moduleTerraform(t, `{"Modules": [{"Key": "", "Source": "", "Dir": "."}]}`)
+	err := tf.GetModule("weakpixel/test-module/tfcli", "")
+	assert.Error(t, err)
+}
+
+func TestFetchModule(t *testing.T) {
+	registry := newTestRegistry(t)
+	dst := filepath.Join(t.TempDir(), "network")
+	result, err := fetchModule(context.Background(), registry.client(registry.credentials()), registry.host+"/acme/network/aws", "~> 1.0", dst)
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "fetch module failed")
+	}
+	assert.Equal(t, &ModuleResult{Source: registry.host + "/acme/network/aws", Version: "1.2.0", Dir: dst}, result)
+	raw, err := ioutil.ReadFile(filepath.Join(dst, "main.tf"))
+	must(t, err)
+	assert.Equal(t, "# network 1.2.0\n", string(raw))
+
+	// subdirectory of a registry module
+	dst = filepath.Join(t.TempDir(), "subnet")
+	result, err = fetchModule(context.Background(), registry.client(registry.credentials()), registry.host+"/acme/network/aws//modules/subnet", "", dst)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2.0.0", result.Version)
+		raw, err := ioutil.ReadFile(filepath.Join(dst, "main.tf"))
+		must(t, err)
+		assert.Equal(t, "# subnet 2.0.0\n", string(raw))
+	}
+
+	// credentials of a credentials source
+	client := registry.client().SetCredentialsSource(StaticCredentials{registry.host: testRegistryToken})
+	result, err = client.FetchModule(context.Background(), registry.host+"/acme/network/aws", "1.0.0", t.TempDir())
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1.0.0", result.Version)
+	}
+
+	_, err = fetchModule(context.Background(), registry.client(), registry.host+"/acme/network/aws", "", t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestFetchModuleLocal(t *testing.T) {
+	src := t.TempDir()
+	must(t, ioutil.WriteFile(filepath.Join(src, "main.tf"), []byte(`resource "null_resource" "test" {}`), 0644))
+	dst := filepath.Join(t.TempDir(), "vendor")
+
+	result, err := FetchModule(context.Background(), src, "", dst)
+	if assert.NoError(t, err) {
+		assert.Equal(t, src, result.Source)
+		assert.Empty(t, result.Version)
+	}
+	info, err := os.Lstat(dst)
+	must(t, err)
+	// modules are copied, not linked
+	assert.True(t, info.IsDir())
+	assert.FileExists(t, filepath.Join(dst, "main.tf"))
+
+	_, err = FetchModule(context.Background(), src, "~> 1.0", t.TempDir())
+	assert.Error(t, err)
+}
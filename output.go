@@ -3,6 +3,8 @@ package tfcli
 import (
 	"encoding/json"
 	"fmt"
+
+	"github.com/zclconf/go-cty/cty"
 )
 
 type tfOutVarible struct {
@@ -38,3 +40,89 @@ func readOutVars(bytes []byte) (map[string]string, error) {
 
 	return resMap, nil
 }
+
+// redacted replaces sensitive values when outputs are printed.
+const redacted = "(sensitive value)"
+
+// OutputValue is a single terraform output as returned by 'terraform output -json'.
+type OutputValue struct {
+	Value     json.RawMessage `json:"value"`
+	Type      cty.Type        `json:"type"`
+	Sensitive bool            `json:"sensitive"`
+}
+
+// Decode unmarshals the output value into target.
+func (o OutputValue) Decode(target interface{}) error {
+	return json.Unmarshal(o.Value, target)
+}
+
+// String returns the raw JSON value. Sensitive values are redacted.
+func (o OutputValue) String() string {
+	if o.Sensitive {
+		return redacted
+	}
+	return string(o.Value)
+}
+
+// GoString makes sure sensitive values are also redacted when printed with '%#v'.
+func (o OutputValue) GoString() string {
+	return fmt.Sprintf("tfcli.OutputValue{Value:%s, Type:%s, Sensitive:%t}", o.String(), o.Type.GoString(), o.Sensitive)
+}
+
+// Outputs contains the terraform outputs by name.
+type Outputs map[string]OutputValue
+
+// Get returns the output with the given name.
+func (o Outputs) Get(name string) (OutputValue, error) {
+	value, ok := o[name]
+	if !ok {
+		return OutputValue{}, fmt.Errorf("output '%s' does not exist", name)
+	}
+	return value, nil
+}
+
+// Decode unmarshals the value of the given output into target.
+func (o Outputs) Decode(name string, target interface{}) error {
+	value, err := o.Get(name)
+	if err != nil {
+		return err
+	}
+	err = value.Decode(target)
+	if err != nil {
+		return fmt.Errorf("cannot decode output '%s': %s", name, err)
+	}
+	return nil
+}
+
+// String returns the value of the given string output.
+func (o Outputs) String(name string) (string, error) {
+	var value string
+	err := o.Decode(name, &value)
+	return value, err
+}
+
+// Int returns the value of the given number output.
+func (o Outputs) Int(name string) (int, error) {
+	var value int
+	err := o.Decode(name, &value)
+	return value, err
+}
+
+// List returns the value of the given list, set or tuple output.
+func (o Outputs) List(name string) ([]interface{}, error) {
+	var value []interface{}
+	err := o.Decode(name, &value)
+	return value, err
+}
+
+func readOutputs(bytes []byte) (Outputs, error) {
+	outputs := Outputs{}
+	if bytes == nil {
+		return outputs, nil
+	}
+	err := json.Unmarshal(bytes, &outputs)
+	if err != nil {
+		return outputs, fmt.Errorf("unable to decode terraform output. Original error: %s", err)
+	}
+	return outputs, nil
+}
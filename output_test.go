@@ -1,7 +1,11 @@
 package tfcli
 
 import (
+	"fmt"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zclconf/go-cty/cty"
 )
 
 func TestReadOutVars(t *testing.T) {
@@ -51,3 +55,68 @@ func TestReadOutVars(t *testing.T) {
 		}
 	}
 }
+
+func TestReadOutputs(t *testing.T) {
+	outputs, err := readOutputs([]byte(`{
+		"name": {
+			"sensitive": false,
+			"type": "string",
+			"value": "my-rg"
+		},
+		"count": {
+			"sensitive": false,
+			"type": "number",
+			"value": 3
+		},
+		"zones": {
+			"sensitive": false,
+			"type": ["list", "string"],
+			"value": ["a", "b"]
+		},
+		"tags": {
+			"sensitive": false,
+			"type": ["map", "string"],
+			"value": {"env": "dev"}
+		},
+		"password": {
+			"sensitive": true,
+			"type": "string",
+			"value": "secret"
+		}
+	}`))
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "readOutputs failed")
+	}
+
+	name, err := outputs.String("name")
+	assert.NoError(t, err)
+	assert.Equal(t, "my-rg", name)
+
+	count, err := outputs.Int("count")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	zones, err := outputs.List("zones")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"a", "b"}, zones)
+	assert.Equal(t, cty.List(cty.String), outputs["zones"].Type)
+
+	tags := map[string]string{}
+	assert.NoError(t, outputs.Decode("tags", &tags))
+	assert.Equal(t, map[string]string{"env": "dev"}, tags)
+
+	_, err = outputs.Int("name")
+	assert.Error(t, err)
+	_, err = outputs.String("missing")
+	assert.Error(t, err)
+
+	password, err := outputs.String("password")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", password)
+	assert.True(t, outputs["password"].Sensitive)
+	for _, format := range []string{"%s", "%v", "%+v", "%#v"} {
+		printed := fmt.Sprintf(format, outputs)
+		assert.NotContains(t, printed, "secret", format)
+		assert.Contains(t, printed, "my-rg", format)
+	}
+}
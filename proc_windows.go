@@ -0,0 +1,26 @@
+//go:build windows
+// +build windows
+
+package tfcli
+
+import (
+	"errors"
+	"os/exec"
+	"strconv"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// interruptProcessGroup is not supported on windows, the caller kills the process instead
+func interruptProcessGroup(cmd *exec.Cmd) error {
+	return errors.New("interrupt is not supported on windows")
+}
+
+// killProcessGroup kills the command and all its child processes
+func killProcessGroup(cmd *exec.Cmd) error {
+	err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+	if err != nil {
+		return cmd.Process.Kill()
+	}
+	return nil
+}
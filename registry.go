@@ -0,0 +1,492 @@
+package tfcli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-version"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultRegistryHost is the registry of module addresses without hostname
+const defaultRegistryHost = "registry.terraform.io"
+
+// modulesServiceID is the service id of the module registry protocol in the discovery document
+const modulesServiceID = "modules.v1"
+
+var (
+	registryNamePattern     = regexp.MustCompile(`^[0-9A-Za-z](?:[0-9A-Za-z-_]{0,62}[0-9A-Za-z])?$`)
+	registryProviderPattern = regexp.MustCompile(`^[0-9a-z]{1,64}$`)
+	registryHostPattern     = regexp.MustCompile(`^[0-9A-Za-z.-]+(:[0-9]+)?$`)
+)
+
+// moduleAddress is a module registry address: [<host>/]<namespace>/<name>/<provider>[//<subdir>]
+type moduleAddress struct {
+	Host      string
+	Namespace string
+	Name      string
+	Provider  string
+	Subdir    string
+}
+
+// parseModuleAddress parses a module registry address.
+// It returns false for all other module sources, e.g. git URLs or local paths.
+func parseModuleAddress(source string) (*moduleAddress, bool) {
+	if strings.HasPrefix(source, ".") || strings.HasPrefix(source, "/") || strings.Contains(source, "::") || strings.Contains(source, "://") {
+		return nil, false
+	}
+	addr := &moduleAddress{Host: defaultRegistryHost}
+	if i := strings.Index(source, "//"); i > -1 {
+		addr.Subdir = strings.Trim(source[i+2:], "/")
+		source = source[:i]
+	}
+	parts := strings.Split(source, "/")
+	if len(parts) == 4 {
+		host := strings.ToLower(parts[0])
+		// these hosts are handled by the go-getter shorthands
+		if host == "github.com" || host == "bitbucket.org" || !registryHostPattern.MatchString(host) ||
+			!(strings.Contains(host, ".") || strings.HasPrefix(host, "localhost")) {
+			return nil, false
+		}
+		addr.Host = host
+		parts = parts[1:]
+	}
+	if len(parts) != 3 || !registryNamePattern.MatchString(parts[0]) || !registryNamePattern.MatchString(parts[1]) ||
+		!registryProviderPattern.MatchString(parts[2]) {
+		return nil, false
+	}
+	addr.Namespace, addr.Name, addr.Provider = parts[0], parts[1], parts[2]
+	return addr, true
+}
+
+func (a *moduleAddress) String() string {
+	s := a.Host + "/" + a.path()
+	if a.Subdir != "" {
+		s += "//" + a.Subdir
+	}
+	return s
+}
+
+// path returns the module path used by the registry API
+func (a *moduleAddress) path() string {
+	return a.Namespace + "/" + a.Name + "/" + a.Provider
+}
+
+// RegistryClient queries module registries, e.g. to offer the available versions of a module.
+// Sources are module registry addresses like 'hashicorp/consul/aws' or 'app.terraform.io/acme/network/aws'.
+type RegistryClient interface {
+	ListVersions(source string) ([]string, error)
+	ListVersionsContext(ctx context.Context, source string) ([]string, error)
+	ResolveVersion(source, constraint string) (string, error)
+	ResolveVersionContext(ctx context.Context, source, constraint string) (string, error)
+	ModuleMetadata(source, version string) (*ModuleMetadata, error)
+	ModuleMetadataContext(ctx context.Context, source, version string) (*ModuleMetadata, error)
+	FetchModule(ctx context.Context, source, version, dst string) (*ModuleResult, error)
+	SetHTTPClient(client *http.Client) RegistryClient
+	SetCredentialsSource(source CredentialsSource) RegistryClient
+}
+
+// ModuleMetadata describes a module version as published by the registry
+type ModuleMetadata struct {
+	Source               string
+	Version              string
+	Description          string
+	Inputs               []ModuleInput
+	Outputs              []ModuleOutput
+	Dependencies         []ModuleDependency
+	ProviderDependencies []ModuleProviderDependency
+}
+
+// ModuleInput is an input variable of a module
+type ModuleInput struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	// Default is the JSON encoded default value, empty if the input has no default
+	Default  string `json:"default"`
+	Required bool   `json:"required"`
+}
+
+// ModuleOutput is an output value of a module
+type ModuleOutput struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// ModuleDependency is a module called by a module
+type ModuleDependency struct {
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	Version string `json:"version"`
+}
+
+// ModuleProviderDependency is a provider required by a module
+type ModuleProviderDependency struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Source    string `json:"source"`
+	Version   string `json:"version"`
+}
+
+// NewRegistryClient creates a registry client. The credentials are used for the registry with the same hostname.
+// Registries without credentials get the token of the credentials source, see SetCredentialsSource,
+// or the TF_TOKEN_<host> environment variable.
+func NewRegistryClient(credentials ...RegistryCredential) RegistryClient {
+	return newRegistryClient(http.DefaultClient, credentials)
+}
+
+// registryClient implements the module registry protocol
+// See https://www.terraform.io/internals/module-registry-protocol
+type registryClient struct {
+	client      *http.Client
+	credentials []RegistryCredential
+	credsSource CredentialsSource
+
+	mu       sync.Mutex
+	services map[string]*url.URL
+}
+
+func newRegistryClient(client *http.Client, credentials []RegistryCredential) *registryClient {
+	return &registryClient{
+		client:      client,
+		credentials: credentials,
+		services:    map[string]*url.URL{},
+	}
+}
+
+// SetHTTPClient sets the client used for registry requests, e.g. to configure a proxy
+func (r *registryClient) SetHTTPClient(client *http.Client) RegistryClient {
+	r.client = client
+	return r
+}
+
+// SetCredentialsSource sets the source of registry tokens for hosts without credentials.
+// The tokens are fetched before every request.
+func (r *registryClient) SetCredentialsSource(source CredentialsSource) RegistryClient {
+	r.credsSource = source
+	return r
+}
+
+// ListVersions returns all versions of the module, oldest first
+func (r *registryClient) ListVersions(source string) ([]string, error) {
+	return r.ListVersionsContext(context.Background(), source)
+}
+
+func (r *registryClient) ListVersionsContext(ctx context.Context, source string) ([]string, error) {
+	addr, err := registryAddress(source)
+	if err != nil {
+		return nil, err
+	}
+	versions, err := r.moduleVersions(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	parsed := []*version.Version{}
+	for _, v := range versions {
+		pv, err := version.NewVersion(v)
+		if err != nil {
+			logrus.Debugf("Ignore invalid version '%s' of module '%s'", v, addr)
+			continue
+		}
+		parsed = append(parsed, pv)
+	}
+	sort.Sort(version.Collection(parsed))
+	result := []string{}
+	for _, v := range parsed {
+		result = append(result, v.Original())
+	}
+	return result, nil
+}
+
+// ResolveVersion returns the newest version of the module matching the constraint, e.g. '~> 1.2'.
+// An empty constraint selects the newest version. Prereleases are only selected by their exact version.
+func (r *registryClient) ResolveVersion(source, constraint string) (string, error) {
+	return r.ResolveVersionContext(context.Background(), source, constraint)
+}
+
+func (r *registryClient) ResolveVersionContext(ctx context.Context, source, constraint string) (string, error) {
+	addr, err := registryAddress(source)
+	if err != nil {
+		return "", err
+	}
+	return r.resolveVersion(ctx, addr, constraint)
+}
+
+// ModuleMetadata returns the inputs, outputs and dependencies of the module version.
+// The metadata is not part of the module registry protocol and is only provided
+// by registries implementing the API of the public registry, e.g. registry.terraform.io.
+// An empty version selects the newest version.
+func (r *registryClient) ModuleMetadata(source, version string) (*ModuleMetadata, error) {
+	return r.ModuleMetadataContext(context.Background(), source, version)
+}
+
+func (r *registryClient) ModuleMetadataContext(ctx context.Context, source, version string) (*ModuleMetadata, error) {
+	addr, err := registryAddress(source)
+	if err != nil {
+		return nil, err
+	}
+	if version == "" {
+		version, err = r.resolveVersion(ctx, addr, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	metadataURL, err := r.moduleURL(ctx, addr, url.PathEscape(version))
+	if err != nil {
+		return nil, err
+	}
+	response := struct {
+		Version     string `json:"version"`
+		Description string `json:"description"`
+		Root        struct {
+			Inputs               []ModuleInput              `json:"inputs"`
+			Outputs              []ModuleOutput             `json:"outputs"`
+			Dependencies         []ModuleDependency         `json:"dependencies"`
+			ProviderDependencies []ModuleProviderDependency `json:"provider_dependencies"`
+		} `json:"root"`
+	}{}
+	err = r.getJSON(ctx, addr.Host, metadataURL.String(), &response)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read metadata of module '%s' version '%s': %s", addr, version, err)
+	}
+	return &ModuleMetadata{
+		Source:               addr.String(),
+		Version:              response.Version,
+		Description:          response.Description,
+		Inputs:               response.Root.Inputs,
+		Outputs:              response.Root.Outputs,
+		Dependencies:         response.Root.Dependencies,
+		ProviderDependencies: response.Root.ProviderDependencies,
+	}, nil
+}
+
+// FetchModule downloads a module into dst with the credentials of the client, see the package function FetchModule
+func (r *registryClient) FetchModule(ctx context.Context, source, version, dst string) (*ModuleResult, error) {
+	return fetchModule(ctx, r, source, version, dst)
+}
+
+// registryAddress parses the source and fails if it is not a module registry address
+func registryAddress(source string) (*moduleAddress, error) {
+	addr, ok := parseModuleAddress(source)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a module registry address", source)
+	}
+	return addr, nil
+}
+
+// token returns the credential of the host. The credentials are preferred over the credentials source,
+// the TF_TOKEN_<host> environment variable is used if neither has a token, like terraform does.
+func (r *registryClient) token(ctx context.Context, host string) (string, error) {
+	for _, c := range r.credentials {
+		if strings.EqualFold(c.Type, host) {
+			return c.Token, nil
+		}
+	}
+	if r.credsSource != nil {
+		tokens, err := r.credsSource.Credentials(ctx)
+		if err != nil {
+			return "", fmt.Errorf("cannot fetch registry credentials: %w", err)
+		}
+		for h, token := range tokens {
+			if strings.EqualFold(h, host) {
+				return token, nil
+			}
+		}
+	}
+	// hosts with a port cannot be passed as environment variable
+	if name, err := tokenEnvName(host); err == nil {
+		return os.Getenv(name), nil
+	}
+	return "", nil
+}
+
+// get requests the url with the credential of the host
+func (r *registryClient) get(ctx context.Context, host, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	token, err := r.token(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("request '%s' failed: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+func (r *registryClient) getJSON(ctx context.Context, host, url string, val interface{}) error {
+	resp, err := r.get(ctx, host, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(raw, val)
+	if err != nil {
+		return fmt.Errorf("invalid response of '%s': %s", url, err)
+	}
+	return nil
+}
+
+// modulesURL returns the base URL of the module registry of the host using the service discovery
+func (r *registryClient) modulesURL(ctx context.Context, host string) (*url.URL, error) {
+	r.mu.Lock()
+	service, ok := r.services[host]
+	r.mu.Unlock()
+	if ok {
+		return service, nil
+	}
+
+	discoveryURL := &url.URL{Scheme: "https", Host: host, Path: "/.well-known/terraform.json"}
+	services := map[string]interface{}{}
+	err := r.getJSON(ctx, host, discoveryURL.String(), &services)
+	if err != nil {
+		return nil, fmt.Errorf("service discovery of '%s' failed: %s", host, err)
+	}
+	location, ok := services[modulesServiceID].(string)
+	if !ok {
+		return nil, fmt.Errorf("host '%s' does not provide a module registry", host)
+	}
+	service, err = discoveryURL.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("host '%s' provides an invalid module registry URL: %s", host, err)
+	}
+	if !strings.HasSuffix(service.Path, "/") {
+		service.Path += "/"
+	}
+	r.mu.Lock()
+	r.services[host] = service
+	r.mu.Unlock()
+	return service, nil
+}
+
+// moduleURL returns the registry API URL of the module with the given path suffix
+func (r *registryClient) moduleURL(ctx context.Context, addr *moduleAddress, suffix string) (*url.URL, error) {
+	service, err := r.modulesURL(ctx, addr.Host)
+	if err != nil {
+		return nil, err
+	}
+	return service.Parse(addr.path() + "/" + suffix)
+}
+
+// moduleVersions returns all versions of the module
+func (r *registryClient) moduleVersions(ctx context.Context, addr *moduleAddress) ([]string, error) {
+	versionsURL, err := r.moduleURL(ctx, addr, "versions")
+	if err != nil {
+		return nil, err
+	}
+	response := struct {
+		Modules []struct {
+			Versions []struct {
+				Version string `json:"version"`
+			} `json:"versions"`
+		} `json:"modules"`
+	}{}
+	err = r.getJSON(ctx, addr.Host, versionsURL.String(), &response)
+	if err != nil {
+		return nil, fmt.Errorf("cannot list versions of module '%s': %s", addr, err)
+	}
+	versions := []string{}
+	for _, m := range response.Modules {
+		for _, v := range m.Versions {
+			versions = append(versions, v.Version)
+		}
+	}
+	return versions, nil
+}
+
+// resolveVersion returns the newest version of the module matching the constraint.
+// An empty constraint matches all versions. Prereleases are only selected by an exact version.
+func (r *registryClient) resolveVersion(ctx context.Context, addr *moduleAddress, constraint string) (string, error) {
+	constraints := version.Constraints{}
+	if constraint != "" {
+		var err error
+		constraints, err = version.NewConstraint(constraint)
+		if err != nil {
+			return "", fmt.Errorf("invalid version constraint '%s': %s", constraint, err)
+		}
+	}
+	versions, err := r.moduleVersions(ctx, addr)
+	if err != nil {
+		return "", err
+	}
+	var newest *version.Version
+	for _, candidate := range versions {
+		v, err := version.NewVersion(candidate)
+		if err != nil {
+			continue
+		}
+		if v.Prerelease() != "" && v.Original() != strings.TrimSpace(constraint) {
+			continue
+		}
+		if constraints.Check(v) && (newest == nil || v.GreaterThan(newest)) {
+			newest = v
+		}
+	}
+	if newest == nil {
+		return "", fmt.Errorf("no version of module '%s' matches '%s'", addr, constraint)
+	}
+	return newest.Original(), nil
+}
+
+// downloadSource returns the go-getter source of the module version
+func (r *registryClient) downloadSource(ctx context.Context, addr *moduleAddress, version string) (string, error) {
+	downloadURL, err := r.moduleURL(ctx, addr, url.PathEscape(version)+"/download")
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.get(ctx, addr.Host, downloadURL.String())
+	if err != nil {
+		return "", fmt.Errorf("cannot download module '%s' version '%s': %s", addr, version, err)
+	}
+	defer resp.Body.Close()
+	location := resp.Header.Get("X-Terraform-Get")
+	if location == "" && resp.StatusCode == http.StatusOK {
+		body := struct {
+			Location string `json:"location"`
+		}{}
+		raw, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+		if err == nil && json.Unmarshal(raw, &body) == nil {
+			location = body.Location
+		}
+	}
+	if location == "" {
+		return "", fmt.Errorf("registry did not return a download location for module '%s' version '%s'", addr, version)
+	}
+	// relative locations are resolved against the download URL
+	if strings.HasPrefix(location, "/") || strings.HasPrefix(location, "./") || strings.HasPrefix(location, "../") {
+		resolved, err := downloadURL.Parse(location)
+		if err != nil {
+			return "", fmt.Errorf("invalid download location '%s' of module '%s': %s", location, addr, err)
+		}
+		location = resolved.String()
+	}
+	// a remote registry must not make us read local files
+	if isLocalLocation(location) {
+		return "", fmt.Errorf("registry returned the local download location '%s' for module '%s' version '%s'", location, addr, version)
+	}
+	return location, nil
+}
@@ -0,0 +1,292 @@
+package tfcli
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testRegistryToken = "secret"
+
+// testRegistry is a module registry serving the module 'acme/network/aws'
+type testRegistry struct {
+	server   *httptest.Server
+	host     string
+	versions []string
+	// location overrides the download location of all versions
+	location string
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{versions: []string{"1.2.0", "1.0.0", "2.0.0", "1.3.0-beta1"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/terraform.json", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, `{"modules.v1": "/api/modules/v1/", "providers.v1": "/api/providers/v1/"}`)
+	})
+	mux.HandleFunc("/api/modules/v1/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer "+testRegistryToken {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		path := strings.TrimPrefix(req.URL.Path, "/api/modules/v1/acme/network/aws/")
+		if path == req.URL.Path {
+			http.NotFound(w, req)
+			return
+		}
+		switch {
+		case path == "versions":
+			versions := []string{}
+			for _, v := range r.versions {
+				versions = append(versions, `{"version": "`+v+`"}`)
+			}
+			fmt.Fprintf(w, `{"modules": [{"versions": [%s]}]}`, strings.Join(versions, ", "))
+		case strings.HasSuffix(path, "/download"):
+			v := strings.TrimSuffix(path, "/download")
+			location := "/archives/network-" + v + ".zip"
+			if r.location != "" {
+				location = r.location
+			}
+			w.Header().Set("X-Terraform-Get", location)
+			w.WriteHeader(http.StatusNoContent)
+		case r.hasVersion(path):
+			fmt.Fprintf(w, `{
+	"id": "acme/network/aws/%[1]s",
+	"version": "%[1]s",
+	"description": "Network module",
+	"root": {
+		"inputs": [
+			{"name": "cidr", "type": "string", "description": "VPC CIDR", "default": "", "required": true},
+			{"name": "region", "type": "string", "description": "AWS region", "default": "\"us-east-1\"", "required": false}
+		],
+		"outputs": [{"name": "vpc_id", "description": "VPC ID"}],
+		"dependencies": [{"name": "subnet", "source": "./modules/subnet", "version": ""}],
+		"provider_dependencies": [{"name": "aws", "namespace": "hashicorp", "source": "hashicorp/aws", "version": ">= 4.0"}],
+		"resources": [{"name": "this", "type": "aws_vpc"}]
+	},
+	"submodules": []
+}`, path)
+		default:
+			http.NotFound(w, req)
+		}
+	})
+	mux.HandleFunc("/archives/", func(w http.ResponseWriter, req *http.Request) {
+		v := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/archives/network-"), ".zip")
+		w.Write(testModuleZip(t, map[string]string{
+			"main.tf":                "# network " + v + "\n",
+			"modules/subnet/main.tf": "# subnet " + v + "\n",
+		}))
+	})
+	r.server = httptest.NewTLSServer(mux)
+	t.Cleanup(r.server.Close)
+	r.host = strings.TrimPrefix(r.server.URL, "https://")
+	return r
+}
+
+func (r *testRegistry) hasVersion(v string) bool {
+	for _, candidate := range r.versions {
+		if candidate == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *testRegistry) client(credentials ...RegistryCredential) *registryClient {
+	return newRegistryClient(r.server.Client(), credentials)
+}
+
+func (r *testRegistry) credentials() RegistryCredential {
+	return RegistryCredential{Type: r.host, Token: testRegistryToken}
+}
+
+func testModuleZip(t *testing.T, files map[string]string) []byte {
+	buffer := &bytes.Buffer{}
+	zw := zip.NewWriter(buffer)
+	for name, content := range files {
+		f, err := zw.Create(name)
+		must(t, err)
+		_, err = f.Write([]byte(content))
+		must(t, err)
+	}
+	must(t, zw.Close())
+	return buffer.Bytes()
+}
+
+func TestParseModuleAddress(t *testing.T) {
+	for source, expected := range map[string]*moduleAddress{
+		"hashicorp/consul/aws":                         {Host: "registry.terraform.io", Namespace: "hashicorp", Name: "consul", Provider: "aws"},
+		"hashicorp/consul/aws//modules/consul-cluster": {Host: "registry.terraform.io", Namespace: "hashicorp", Name: "consul", Provider: "aws", Subdir: "modules/consul-cluster"},
+		"app.terraform.io/acme/network/aws":            {Host: "app.terraform.io", Namespace: "acme", Name: "network", Provider: "aws"},
+		"localhost:8443/acme/network_v2/aws":           {Host: "localhost:8443", Namespace: "acme", Name: "network_v2", Provider: "aws"},
+	} {
+		addr, ok := parseModuleAddress(source)
+		if assert.True(t, ok, source) {
+			assert.Equal(t, expected, addr, source)
+			assert.True(t, strings.HasSuffix(addr.String(), source), source)
+		}
+	}
+	for _, source := range []string{
+		"./modules/network",
+		"../network",
+		"/opt/modules/network",
+		"github.com/hashicorp/example",
+		"github.com/hashicorp/example/aws",
+		"bitbucket.org/acme/network/aws",
+		"git::https://example.com/network.git",
+		"https://example.com/network.zip",
+		"s3::https://s3.amazonaws.com/bucket/network.zip",
+		"acme/network",
+		"acme/network/AWS",
+		"example/acme/network/aws",
+	} {
+		_, ok := parseModuleAddress(source)
+		assert.False(t, ok, source)
+	}
+}
+
+func TestRegistryClientResolveVersion(t *testing.T) {
+	registry := newTestRegistry(t)
+	client := registry.client(registry.credentials())
+	addr, _ := parseModuleAddress(registry.host + "/acme/network/aws")
+
+	for constraint, expected := range map[string]string{
+		"":            "2.0.0",
+		"~> 1.0":      "1.2.0",
+		"< 2.0":       "1.2.0",
+		"1.0.0":       "1.0.0",
+		"1.3.0-beta1": "1.3.0-beta1",
+	} {
+		v, err := client.resolveVersion(context.Background(), addr, constraint)
+		if assert.NoError(t, err, constraint) {
+			assert.Equal(t, expected, v, constraint)
+		}
+	}
+	_, err := client.resolveVersion(context.Background(), addr, "> 2.0")
+	assert.Error(t, err)
+	_, err = client.resolveVersion(context.Background(), addr, "invalid")
+	assert.Error(t, err)
+
+	// the registry requires credentials
+	_, err = registry.client().resolveVersion(context.Background(), addr, "")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "401")
+	}
+}
+
+func TestRegistryClientToken(t *testing.T) {
+	t.Setenv("TF_TOKEN_registry_example__corp_com", "env-token")
+	client := newRegistryClient(http.DefaultClient, []RegistryCredential{{Type: "app.terraform.io", Token: "static-token"}})
+	client.credsSource = StaticCredentials{"Registry.Example.com": "source-token"}
+	for host, expected := range map[string]string{
+		"app.terraform.io":          "static-token",
+		"registry.example.com":      "source-token",
+		"registry.example-corp.com": "env-token",
+		"localhost:8443":            "",
+	} {
+		token, err := client.token(context.Background(), host)
+		if assert.NoError(t, err, host) {
+			assert.Equal(t, expected, token, host)
+		}
+	}
+
+	client.credsSource = CredentialsFunc(func(ctx context.Context) (map[string]string, error) {
+		return nil, fmt.Errorf("secret store unavailable")
+	})
+	_, err := client.token(context.Background(), "registry.example.com")
+	assert.Error(t, err)
+}
+
+func TestRegistryClientDownloadSource(t *testing.T) {
+	registry := newTestRegistry(t)
+	addr, _ := parseModuleAddress(registry.host + "/acme/network/aws")
+	location, err := registry.client(registry.credentials()).downloadSource(context.Background(), addr, "1.2.0")
+	if assert.NoError(t, err) {
+		assert.Equal(t, registry.server.URL+"/archives/network-1.2.0.zip", location)
+	}
+
+	// the registry must not point to local files
+	for _, local := range []string{"file:///etc", "file::/etc", "C:\\modules\\network", "\\\\server\\share"} {
+		registry.location = local
+		_, err := registry.client(registry.credentials()).downloadSource(context.Background(), addr, "1.2.0")
+		assert.Error(t, err, local)
+	}
+}
+
+func TestIsLocalLocation(t *testing.T) {
+	for _, location := range []string{"./network", "../network", "/opt/network", "file:///opt/network", "FILE::https://example.com/a", "C:/modules", "c:\\modules", "\\\\server\\share"} {
+		assert.True(t, isLocalLocation(location), location)
+	}
+	for _, location := range []string{"https://example.com/network.zip", "git::https://example.com/network.git", "s3::https://s3.amazonaws.com/bucket/network.zip", "github.com/acme/network"} {
+		assert.False(t, isLocalLocation(location), location)
+	}
+}
+
+func TestRegistryClientListVersions(t *testing.T) {
+	registry := newTestRegistry(t)
+	client := NewRegistryClient(registry.credentials()).SetHTTPClient(registry.server.Client())
+	versions, err := client.ListVersions(registry.host + "/acme/network/aws")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"1.0.0", "1.2.0", "1.3.0-beta1", "2.0.0"}, versions)
+	}
+	v, err := client.ResolveVersion(registry.host+"/acme/network/aws", "~> 1.0")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1.2.0", v)
+	}
+
+	// credentials of a credentials source
+	client = NewRegistryClient().SetHTTPClient(registry.server.Client()).
+		SetCredentialsSource(StaticCredentials{registry.host: testRegistryToken})
+	versions, err = client.ListVersions(registry.host + "/acme/network/aws")
+	if assert.NoError(t, err) {
+		assert.Len(t, versions, 4)
+	}
+
+	_, err = client.ListVersions("./modules/network")
+	assert.Error(t, err)
+	_, err = client.ResolveVersion("git::https://example.com/network.git", "")
+	assert.Error(t, err)
+}
+
+func TestRegistryClientModuleMetadata(t *testing.T) {
+	registry := newTestRegistry(t)
+	client := NewRegistryClient(registry.credentials()).SetHTTPClient(registry.server.Client())
+	metadata, err := client.ModuleMetadata(registry.host+"/acme/network/aws", "1.2.0")
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "module metadata failed")
+	}
+	assert.Equal(t, &ModuleMetadata{
+		Source:      registry.host + "/acme/network/aws",
+		Version:     "1.2.0",
+		Description: "Network module",
+		Inputs: []ModuleInput{
+			{Name: "cidr", Type: "string", Description: "VPC CIDR", Required: true},
+			{Name: "region", Type: "string", Description: "AWS region", Default: `"us-east-1"`},
+		},
+		Outputs:              []ModuleOutput{{Name: "vpc_id", Description: "VPC ID"}},
+		Dependencies:         []ModuleDependency{{Name: "subnet", Source: "./modules/subnet"}},
+		ProviderDependencies: []ModuleProviderDependency{{Name: "aws", Namespace: "hashicorp", Source: "hashicorp/aws", Version: ">= 4.0"}},
+	}, metadata)
+
+	// an empty version selects the newest version
+	metadata, err = client.ModuleMetadata(registry.host+"/acme/network/aws", "")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "2.0.0", metadata.Version)
+	}
+
+	_, err = client.ModuleMetadata(registry.host+"/acme/network/aws", "9.9.9")
+	assert.Error(t, err)
+}
+
+func TestJoinSubdir(t *testing.T) {
+	assert.Equal(t, "https://example.com/a.zip", joinSubdir("https://example.com/a.zip", ""))
+	assert.Equal(t, "https://example.com/a.zip//sub", joinSubdir("https://example.com/a.zip", "sub"))
+	assert.Equal(t, "git::https://example.com/a.git//modules/sub?ref=v1", joinSubdir("git::https://example.com/a.git//modules?ref=v1", "sub"))
+	assert.Equal(t, "git::https://example.com/a.git//sub?ref=v1", joinSubdir("git::https://example.com/a.git?ref=v1", "sub"))
+}
@@ -0,0 +1,189 @@
+package tfcli
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/stretchr/testify/assert"
+)
+
+// testRelease serves fake terraform releases signed with a test key
+type testRelease struct {
+	server    *httptest.Server
+	publicKey string
+	files     map[string][]byte
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (r *testRelease) requestCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+func newTestRelease(t *testing.T, versions ...string) *testRelease {
+	entity, err := openpgp.NewEntity("tfcli test", "", "test@example.com", nil)
+	must(t, err)
+	keyBuffer := &bytes.Buffer{}
+	w, err := armor.Encode(keyBuffer, openpgp.PublicKeyType, nil)
+	must(t, err)
+	must(t, entity.Serialize(w))
+	must(t, w.Close())
+
+	r := &testRelease{
+		publicKey: keyBuffer.String(),
+		files:     map[string][]byte{},
+	}
+	platforms := [][2]string{{runtime.GOOS, runtime.GOARCH}, {"windows", "arm64"}}
+	builds := []releaseIndexBuild{}
+	for _, platform := range platforms {
+		builds = append(builds, releaseIndexBuild{OS: platform[0], Arch: platform[1]})
+	}
+	// prereleases are listed in the index but never resolved
+	index := releaseIndex{Versions: map[string]releaseIndexVersion{
+		"9.9.9-beta1": {Version: "9.9.9-beta1", Builds: builds},
+	}}
+	for _, version := range versions {
+		index.Versions[version] = releaseIndexVersion{Version: version, Builds: builds}
+		sums := ""
+		for _, platform := range platforms {
+			archive := testReleaseZip(t, version, platform[0])
+			zipName := releaseZipName(version, platform[0], platform[1])
+			hash := sha256.Sum256(archive)
+			sums += fmt.Sprintf("%s  %s\n", hex.EncodeToString(hash[:]), zipName)
+			r.files["/terraform/"+version+"/"+zipName] = archive
+		}
+		sums += fmt.Sprintf("%s  %s\n", strings.Repeat("0", 64), releaseZipName(version, "plan9", "mips"))
+		sig := &bytes.Buffer{}
+		must(t, openpgp.DetachSign(sig, entity, strings.NewReader(sums), nil))
+
+		sumsName := fmt.Sprintf("terraform_%s_SHA256SUMS", version)
+		r.files["/terraform/"+version+"/"+sumsName] = []byte(sums)
+		r.files["/terraform/"+version+"/"+sumsName+".sig"] = sig.Bytes()
+	}
+	rawIndex, err := json.Marshal(index)
+	must(t, err)
+	r.files["/terraform/index.json"] = rawIndex
+	r.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		r.requests = append(r.requests, req.URL.Path)
+		r.mu.Unlock()
+		content, ok := r.files[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(content)
+	}))
+	t.Cleanup(r.server.Close)
+	return r
+}
+
+// testReleaseZip returns a release archive containing a fake terraform executable
+func testReleaseZip(t *testing.T, version, goos string) []byte {
+	name := "terraform"
+	if goos == "windows" {
+		name = "terraform.exe"
+	}
+	buffer := &bytes.Buffer{}
+	zw := zip.NewWriter(buffer)
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+	header.SetMode(0755)
+	f, err := zw.CreateHeader(header)
+	must(t, err)
+	_, err = f.Write([]byte("#!/bin/sh\necho '{\"terraform_version\": \"" + version + "\"}'\n"))
+	must(t, err)
+	must(t, zw.Close())
+	return buffer.Bytes()
+}
+
+// testHashicorpChecksums and testHashicorpSignature are the SHA256SUMS and SHA256SUMS.sig
+// of the official terraform-provider-null 3.1.0 release, signed in April 2021
+// with the HashiCorp signing subkey B0B441097685B676 which expired in April 2022.
+const testHashicorpChecksums = `fea4227271ebf7d9e2b61b89ce2328c7262acd9fd190e1fd6d15a591abfa848e  terraform-provider-null_3.1.0_darwin_amd64.zip
+9ebf4d9704faba06b3ec7242c773c0fbfe12d62db7d00356d4f55385fc69bfb2  terraform-provider-null_3.1.0_darwin_arm64.zip
+a6576c81adc70326e4e1c999c04ad9ca37113a6e925aefab4765e5a5198efa7e  terraform-provider-null_3.1.0_freebsd_386.zip
+5f9200bf708913621d0f6514179d89700e9aa3097c77dac730e8ba6e5901d521  terraform-provider-null_3.1.0_freebsd_amd64.zip
+fc39cc1fe71234a0b0369d5c5c7f876c71b956d23d7d6f518289737a001ba69b  terraform-provider-null_3.1.0_freebsd_arm.zip
+c797744d08a5307d50210e0454f91ca4d1c7621c68740441cf4579390452321d  terraform-provider-null_3.1.0_linux_386.zip
+53e30545ff8926a8e30ad30648991ca8b93b6fa496272cd23b26763c8ee84515  terraform-provider-null_3.1.0_linux_amd64.zip
+cecb6a304046df34c11229f20a80b24b1603960b794d68361a67c5efe58e62b8  terraform-provider-null_3.1.0_linux_arm64.zip
+e1371aa1e502000d9974cfaff5be4cfa02f47b17400005a16f14d2ef30dc2a70  terraform-provider-null_3.1.0_linux_arm.zip
+a8a42d13346347aff6c63a37cda9b2c6aa5cc384a55b2fe6d6adfa390e609c53  terraform-provider-null_3.1.0_windows_386.zip
+02a1675fd8de126a00460942aaae242e65ca3380b5bb192e8773ef3da9073fd2  terraform-provider-null_3.1.0_windows_amd64.zip
+`
+
+const testHashicorpSignature = `wsFcBAABCAAQBQJgga+GCRCwtEEJdoW2dgAA` +
+	`o0YQAAW911BGDr2WHLo5NwcZenwHyxL5DX9g+4BknKbc/WxRC1hD8Afi3eygZk1yR6eT4Gp2H` +
+	`yNOwCjGL1PTONBumMfj9udIeuX8onrJMMvjFHh+bORGxBi4FKr4V3b2ZV1IYOjWMEyyTGRDvw` +
+	`SCdxBkp3apH3s2xZLmRoAj84JZ4KaxGF7hlT0j4IkNyQKd2T5cCByN9DV80+x+HtzaOieFwJL` +
+	`97iyGj6aznXfKfslK6S4oIrVTwyLTrQbxSxA0LsdUjRPHnJamL3sFOG77qUEUoXG3r61yi5vW` +
+	`V4P5gCH/+C+VkfGHqaB1s0jHYLxoTEXtwthe66MydDBPe2Hd0J12u9ppOIeK3leeb4uiixWIi` +
+	`rNdpWyjr/LU1KKWPxsDqMGYJ9TexyWkXjEpYmIEiY1Rxar8jrLh+FqVAhxRJajjgSRu5pZj50` +
+	`CNeKmmbyolLhPCmICjYYU/xKPGXSyDFqonVVyMWCSpO+8F38OmwDQHIk5AWyc8hPOAZ+g5N95` +
+	`cfUAzEqlvmNvVHQIU40Y6/Ip2HZzzFCLKQkMP1aDakYHq5w4ZO/ucjhKuoh1HDQMuMnZSu4eo` +
+	`2nMTBzYZnUxwtROrJZF1t103avbmP2QE/GaPvLIQn7o5WMV3ZcPCJ+szzzby7H2e33WIynrY/` +
+	`95ensBxh7mGFbcQ1C59b5o7viwIaaY2`
+
+func TestHashicorpPublicKey(t *testing.T) {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(hashicorpPublicKey))
+	if !assert.NoError(t, err) || !assert.Len(t, keyring, 1) {
+		assert.FailNow(t, "invalid public key")
+	}
+	assert.Equal(t, "34365D9472D7468F", keyring[0].PrimaryKey.KeyIdString())
+	key, ok := keyring[0].SigningKey(time.Now())
+	if assert.True(t, ok, "no valid signing key") {
+		assert.Equal(t, "C820C6D5CD27AB87", key.PublicKey.KeyIdString())
+	}
+	_, ok = keyring[0].SigningKey(time.Date(2030, 3, 2, 0, 0, 0, 0, time.UTC))
+	assert.False(t, ok, "signing key does not expire")
+
+	// signatures of the expired subkey are rejected
+	sig, err := base64.StdEncoding.DecodeString(testHashicorpSignature)
+	must(t, err)
+	err = verifySignature(hashicorpPublicKey, []byte(testHashicorpChecksums), sig)
+	assert.Error(t, err)
+}
+
+func TestVerifySignatureExpiredKey(t *testing.T) {
+	created := time.Now().Add(-48 * time.Hour)
+	config := &packet.Config{Time: func() time.Time { return created }, KeyLifetimeSecs: 24 * 60 * 60}
+	entity, err := openpgp.NewEntity("tfcli test", "", "test@example.com", config)
+	must(t, err)
+	keyBuffer := &bytes.Buffer{}
+	w, err := armor.Encode(keyBuffer, openpgp.PublicKeyType, nil)
+	must(t, err)
+	must(t, entity.Serialize(w))
+	must(t, w.Close())
+	sig := &bytes.Buffer{}
+	must(t, openpgp.DetachSign(sig, entity, strings.NewReader("checksums"), config))
+
+	assert.NoError(t, verifySignatureAt(keyBuffer.String(), []byte("checksums"), sig.Bytes(), created.Add(time.Hour)))
+	assert.Error(t, verifySignature(keyBuffer.String(), []byte("checksums"), sig.Bytes()))
+}
+
+func TestReadChecksums(t *testing.T) {
+	sums, err := readChecksums([]byte("abc  file_a.zip\n\ndef  file_b.zip\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"file_a.zip": "abc", "file_b.zip": "def"}, sums)
+
+	_, err = readChecksums([]byte("invalid line with spaces"))
+	assert.Error(t, err)
+}
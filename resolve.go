@@ -0,0 +1,259 @@
+package tfcli
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// DefaultIndexTTL is the time a downloaded release index is used before it is fetched again
+const DefaultIndexTTL = time.Hour
+
+// indexFileName returns the name of the cached release index of the release server in the cache directory.
+// Each release server has its own index, e.g. a mirror may not provide all releases.
+func indexFileName(baseURL string) string {
+	hash := sha256.Sum256([]byte(strings.TrimSuffix(baseURL, "/")))
+	return "index-" + hex.EncodeToString(hash[:8]) + ".json"
+}
+
+// releaseIndex is the list of releases published in '<baseURL>/terraform/index.json'
+type releaseIndex struct {
+	Versions map[string]releaseIndexVersion `json:"versions"`
+}
+
+type releaseIndexVersion struct {
+	Version string              `json:"version"`
+	Builds  []releaseIndexBuild `json:"builds"`
+}
+
+type releaseIndexBuild struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+}
+
+// isExactVersion returns true if the version is a full version instead of a constraint
+func isExactVersion(v string) bool {
+	parsed, err := version.NewVersion(v)
+	return err == nil && parsed.String() == v
+}
+
+// Resolve returns the newest stable version matching the constraint, e.g. '~> 1.5' or '>= 1.3, < 2.0'.
+// In offline mode only cached versions are considered.
+func (d *downloader) Resolve(constraint string) (string, error) {
+	return d.ResolveContext(context.Background(), constraint)
+}
+
+func (d *downloader) ResolveContext(ctx context.Context, constraint string) (string, error) {
+	constraints, err := version.NewConstraint(constraint)
+	if err != nil {
+		return "", fmt.Errorf("invalid version constraint '%s': %s", constraint, err)
+	}
+	var candidates []string
+	if d.offline {
+		candidates, err = d.cachedVersions()
+	} else {
+		candidates, err = d.indexVersions(ctx)
+	}
+	if err != nil {
+		return "", err
+	}
+	var newest *version.Version
+	for _, candidate := range candidates {
+		v, err := version.NewVersion(candidate)
+		if err != nil || v.Prerelease() != "" || v.Metadata() != "" {
+			continue
+		}
+		if constraints.Check(v) && (newest == nil || v.GreaterThan(newest)) {
+			newest = v
+		}
+	}
+	if newest == nil {
+		return "", fmt.Errorf("no terraform release for %s_%s matches '%s'", d.goos, d.goarch, constraint)
+	}
+	return newest.Original(), nil
+}
+
+// SetIndexTTL sets how long the cached release index is used before it is fetched again
+func (d *downloader) SetIndexTTL(ttl time.Duration) Downloader {
+	d.indexTTL = ttl
+	return d
+}
+
+func (d *downloader) IndexTTL() time.Duration {
+	return d.indexTTL
+}
+
+// DownloadForModule downloads the newest version matching the 'required_version'
+// of the module in dir. The newest stable version is used if the module has no constraint.
+func (d *downloader) DownloadForModule(dir string, force bool) (string, error) {
+	return d.DownloadForModuleContext(context.Background(), dir, force)
+}
+
+func (d *downloader) DownloadForModuleContext(ctx context.Context, dir string, force bool) (string, error) {
+	constraint, err := RequiredVersion(dir)
+	if err != nil {
+		return "", err
+	}
+	if constraint == "" {
+		constraint = ">= 0"
+	}
+	v, err := d.ResolveContext(ctx, constraint)
+	if err != nil {
+		return "", err
+	}
+	return d.DownloadContext(ctx, v, force)
+}
+
+// indexVersions returns the released versions which provide a build for the platform
+func (d *downloader) indexVersions(ctx context.Context) ([]string, error) {
+	index, err := d.releaseIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	versions := []string{}
+	for _, release := range index.Versions {
+		for _, build := range release.Builds {
+			if build.OS == d.goos && build.Arch == d.goarch {
+				versions = append(versions, release.Version)
+				break
+			}
+		}
+	}
+	return versions, nil
+}
+
+// releaseIndex returns the cached release index or fetches it if the cache expired
+func (d *downloader) releaseIndex(ctx context.Context) (*releaseIndex, error) {
+	cacheDir, err := d.resolvedCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	indexFile := filepath.Join(cacheDir, indexFileName(d.baseURL))
+	if info, err := os.Stat(indexFile); err == nil && time.Since(info.ModTime()) < d.indexTTL {
+		raw, err := ioutil.ReadFile(indexFile)
+		if err == nil {
+			index, err := readReleaseIndex(raw)
+			if err == nil {
+				return index, nil
+			}
+		}
+	}
+
+	raw, err := httpGet(ctx, d.client, strings.TrimSuffix(d.baseURL, "/")+"/terraform/index.json")
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch release index: %s", err)
+	}
+	index, err := readReleaseIndex(raw)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(cacheDir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	// replace the index atomically, other processes may read it concurrently
+	tmp, err := ioutil.TempFile(cacheDir, "."+filepath.Base(indexFile)+"-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(raw)
+	tmp.Close()
+	if err != nil {
+		return nil, err
+	}
+	return index, os.Rename(tmp.Name(), indexFile)
+}
+
+func readReleaseIndex(raw []byte) (*releaseIndex, error) {
+	index := &releaseIndex{}
+	err := json.Unmarshal(raw, index)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read release index: %s", err)
+	}
+	return index, nil
+}
+
+// cachedVersions returns the versions in the cache directory for the platform
+func (d *downloader) cachedVersions() ([]string, error) {
+	cached, err := d.ListCachedVersions()
+	if err != nil {
+		return nil, err
+	}
+	versions := []string{}
+	for _, c := range cached {
+		if c.GOOS == d.goos && c.GOARCH == d.goarch {
+			versions = append(versions, c.Version)
+		}
+	}
+	return versions, nil
+}
+
+// RequiredVersion returns the 'required_version' constraints of the terraform blocks
+// of the module in dir. It returns an empty string if the module has no constraint.
+func RequiredVersion(dir string) (string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return "", err
+	}
+	jsonFiles, err := filepath.Glob(filepath.Join(dir, "*.tf.json"))
+	if err != nil {
+		return "", err
+	}
+	schema := &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "terraform"}},
+	}
+	terraformSchema := &hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: "required_version"}},
+	}
+
+	parser := hclparse.NewParser()
+	constraints := []string{}
+	for _, file := range append(files, jsonFiles...) {
+		var f *hcl.File
+		var diags hcl.Diagnostics
+		if strings.HasSuffix(file, ".json") {
+			f, diags = parser.ParseJSONFile(file)
+		} else {
+			f, diags = parser.ParseHCLFile(file)
+		}
+		if diags.HasErrors() {
+			return "", fmt.Errorf("cannot parse '%s': %s", file, diags.Error())
+		}
+		content, _, diags := f.Body.PartialContent(schema)
+		if diags.HasErrors() {
+			return "", fmt.Errorf("cannot parse '%s': %s", file, diags.Error())
+		}
+		for _, block := range content.Blocks {
+			attrs, _, diags := block.Body.PartialContent(terraformSchema)
+			if diags.HasErrors() {
+				return "", fmt.Errorf("cannot parse '%s': %s", file, diags.Error())
+			}
+			attr, ok := attrs.Attributes["required_version"]
+			if !ok {
+				continue
+			}
+			val, diags := attr.Expr.Value(nil)
+			if diags.HasErrors() {
+				return "", fmt.Errorf("invalid required_version in '%s': %s", file, diags.Error())
+			}
+			if val.IsNull() || !val.IsKnown() || val.Type() != cty.String {
+				return "", fmt.Errorf("invalid required_version in '%s': must be a string", file)
+			}
+			constraints = append(constraints, val.AsString())
+		}
+	}
+	return strings.Join(constraints, ", "), nil
+}
@@ -0,0 +1,152 @@
+package tfcli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsExactVersion(t *testing.T) {
+	assert.True(t, isExactVersion("1.1.6"))
+	assert.True(t, isExactVersion("1.2.0-beta1"))
+	assert.False(t, isExactVersion("1.1"))
+	assert.False(t, isExactVersion("~> 1.1"))
+	assert.False(t, isExactVersion(">= 1.3, < 2.0"))
+}
+
+func TestResolve(t *testing.T) {
+	release := newTestRelease(t, "1.1.6", "1.1.9", "1.3.2", "1.5.0", "1.5.7", "2.0.1")
+	d := testDownloader(t, release)
+
+	for constraint, expected := range map[string]string{
+		"~> 1.5":        "1.5.7",
+		"~> 1.1.0":      "1.1.9",
+		">= 1.3, < 2.0": "1.5.7",
+		"< 1.3":         "1.1.9",
+		"1.3.2":         "1.3.2",
+		">= 0":          "2.0.1",
+	} {
+		v, err := d.Resolve(constraint)
+		if assert.NoError(t, err, constraint) {
+			assert.Equal(t, expected, v, constraint)
+		}
+	}
+	_, err := d.Resolve("> 3.0")
+	assert.Error(t, err)
+	_, err = d.Resolve("not a constraint")
+	assert.Error(t, err)
+
+	// the index is fetched once and cached afterwards
+	assert.Equal(t, 1, release.requestCount())
+	assert.FileExists(t, filepath.Join(d.CacheDir(), indexFileName(release.server.URL)))
+
+	// expired index
+	assert.Equal(t, DefaultIndexTTL, d.IndexTTL())
+	_, err = d.SetIndexTTL(0).Resolve("~> 1.5")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, release.requestCount())
+}
+
+func TestResolveOffline(t *testing.T) {
+	release := newTestRelease(t, "1.1.6", "1.5.7")
+	d := testDownloader(t, release)
+	_, err := d.Download("1.1.6", false)
+	must(t, err)
+
+	v, err := d.SetOffline(true).Resolve(">= 1.0")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1.1.6", v)
+	}
+	_, err = d.SetPlatform("windows", "arm64").Resolve(">= 1.0")
+	assert.Error(t, err)
+}
+
+func TestDownloadConstraint(t *testing.T) {
+	release := newTestRelease(t, "1.1.6", "1.5.0", "1.5.7")
+	d := testDownloader(t, release)
+	tffile, err := d.Download("~> 1.5", false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, filepath.Join(d.CacheDir(), cachedDirName("1.5.7")), filepath.Dir(tffile))
+	}
+}
+
+func TestRequiredVersion(t *testing.T) {
+	dir := t.TempDir()
+	constraint, err := RequiredVersion(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, constraint)
+
+	must(t, ioutil.WriteFile(filepath.Join(dir, "main.tf"), []byte(`
+terraform {
+  required_version = "~> 1.5"
+  required_providers {
+    null = {
+      source = "hashicorp/null"
+    }
+  }
+}
+
+resource "null_resource" "test" {}
+`), 0644))
+	must(t, ioutil.WriteFile(filepath.Join(dir, "versions.tf.json"), []byte(`{"terraform": {"required_version": "< 1.5.5"}}`), 0644))
+	constraint, err = RequiredVersion(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "~> 1.5, < 1.5.5", constraint)
+
+	release := newTestRelease(t, "1.1.6", "1.5.0", "1.5.7")
+	tffile, err := testDownloader(t, release).DownloadForModule(dir, false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, cachedDirName("1.5.0"), filepath.Base(filepath.Dir(tffile)))
+	}
+
+	must(t, ioutil.WriteFile(filepath.Join(dir, "versions.tf.json"), []byte(`{"terraform": {"required_version": 1}}`), 0644))
+	_, err = RequiredVersion(dir)
+	assert.Error(t, err)
+	must(t, os.Remove(filepath.Join(dir, "versions.tf.json")))
+
+	must(t, ioutil.WriteFile(filepath.Join(dir, "broken.tf"), []byte(`terraform {`), 0644))
+	_, err = RequiredVersion(dir)
+	assert.Error(t, err)
+}
+
+func TestReleaseIndexCache(t *testing.T) {
+	release := newTestRelease(t, "1.1.6")
+	d := testDownloader(t, release).SetIndexTTL(time.Hour)
+	indexFile := filepath.Join(d.CacheDir(), indexFileName(release.server.URL))
+	must(t, ioutil.WriteFile(indexFile, []byte(`{"versions": {"1.0.0": {"version": "1.0.0", "builds": [{"os": "windows", "arch": "arm64"}]}}}`), 0644))
+
+	v, err := d.SetPlatform("windows", "arm64").Resolve(">= 1.0")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1.0.0", v)
+	}
+	assert.Zero(t, release.requestCount())
+
+	old := time.Now().Add(-2 * time.Hour)
+	must(t, os.Chtimes(indexFile, old, old))
+	v, err = d.Resolve(">= 1.0")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1.1.6", v)
+	}
+}
+
+func TestReleaseIndexPerBaseURL(t *testing.T) {
+	assert.Equal(t, indexFileName("https://releases.example.com"), indexFileName("https://releases.example.com/"))
+	assert.NotEqual(t, indexFileName(defaultReleasesURL), indexFileName("https://releases.example.com"))
+
+	release := newTestRelease(t, "1.1.6")
+	mirror := newTestRelease(t, "1.1.6", "1.5.7")
+	d := testDownloader(t, release).SetIndexTTL(time.Hour)
+	v, err := d.Resolve(">= 1.0")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1.1.6", v)
+	}
+	// the index of the previous release server is not used for the mirror
+	v, err = d.SetBaseURL(mirror.server.URL).SetHTTPClient(mirror.server.Client()).Resolve(">= 1.0")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1.5.7", v)
+	}
+}
@@ -0,0 +1,88 @@
+package tfcli
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/go-version"
+)
+
+var (
+	// minReplaceVersion is the first terraform version supporting -replace
+	minReplaceVersion = version.Must(version.NewVersion("0.15.2"))
+	// minRefreshOnlyVersion is the first terraform version supporting -refresh-only
+	minRefreshOnlyVersion = version.Must(version.NewVersion("0.15.4"))
+)
+
+// RunOptions are additional options for plan, apply and destroy
+type RunOptions struct {
+	// Targets limits the operation to the given resource addresses (-target)
+	Targets []string
+	// Replace forces the replacement of the given resource addresses (-replace)
+	Replace []string
+	// RefreshOnly only updates the state to match remote objects (-refresh-only)
+	RefreshOnly bool
+	// NoRefresh skips the refresh of remote objects (-refresh=false)
+	NoRefresh bool
+	// Parallelism limits the number of concurrent operations (-parallelism)
+	Parallelism int
+	// LockTimeout is the duration to retry acquiring the state lock (-lock-timeout)
+	LockTimeout time.Duration
+	// VarFiles are additional variable definition files (-var-file)
+	VarFiles []string
+}
+
+// requiredVersion returns the minimal terraform version supporting the options
+func (o RunOptions) requiredVersion() *version.Version {
+	if o.RefreshOnly {
+		return minRefreshOnlyVersion
+	}
+	if len(o.Replace) > 0 {
+		return minReplaceVersion
+	}
+	return nil
+}
+
+// args returns the command line arguments for the given command.
+// A saved plan ('apply' with plan file) only supports parallelism and lock timeout.
+func (o RunOptions) args(command string, savedPlan bool) ([]string, error) {
+	if o.RefreshOnly && o.NoRefresh {
+		return nil, fmt.Errorf("refresh only and no refresh cannot be combined")
+	}
+	if o.RefreshOnly && len(o.Replace) > 0 {
+		return nil, fmt.Errorf("refresh only and replace cannot be combined")
+	}
+	if savedPlan && (len(o.Targets) > 0 || len(o.Replace) > 0 || len(o.VarFiles) > 0 || o.RefreshOnly || o.NoRefresh) {
+		return nil, fmt.Errorf("a saved plan only supports parallelism and lock timeout, the other options are part of the plan")
+	}
+	if command == "destroy" && (o.RefreshOnly || len(o.Replace) > 0) {
+		return nil, fmt.Errorf("refresh only and replace are not supported by destroy")
+	}
+	args := []string{}
+	if o.Parallelism > 0 {
+		args = append(args, "-parallelism="+strconv.Itoa(o.Parallelism))
+	}
+	if o.LockTimeout > 0 {
+		args = append(args, "-lock-timeout="+o.LockTimeout.String())
+	}
+	if savedPlan {
+		return args, nil
+	}
+	if o.RefreshOnly {
+		args = append(args, "-refresh-only")
+	}
+	if o.NoRefresh {
+		args = append(args, "-refresh=false")
+	}
+	for _, target := range o.Targets {
+		args = append(args, "-target="+target)
+	}
+	for _, replace := range o.Replace {
+		args = append(args, "-replace="+replace)
+	}
+	for _, file := range o.VarFiles {
+		args = append(args, "-var-file="+file)
+	}
+	return args, nil
+}
@@ -0,0 +1,93 @@
+package tfcli
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunOptionsArgs(t *testing.T) {
+	opts := RunOptions{
+		Targets:     []string{"aws_instance.a", "module.b"},
+		Replace:     []string{"aws_instance.a"},
+		NoRefresh:   true,
+		Parallelism: 5,
+		LockTimeout: 90 * time.Second,
+		VarFiles:    []string{"prod.tfvars"},
+	}
+	args, err := opts.args("apply", false)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"-parallelism=5", "-lock-timeout=1m30s", "-refresh=false",
+		"-target=aws_instance.a", "-target=module.b", "-replace=aws_instance.a", "-var-file=prod.tfvars",
+	}, args)
+
+	// options of the saved plan are rejected
+	_, err = opts.args("apply", true)
+	assert.Error(t, err)
+	args, err = RunOptions{Parallelism: 5, LockTimeout: 90 * time.Second}.args("apply", true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"-parallelism=5", "-lock-timeout=1m30s"}, args)
+	for _, o := range []RunOptions{
+		{Targets: []string{"a"}}, {Replace: []string{"a"}}, {VarFiles: []string{"a"}}, {RefreshOnly: true}, {NoRefresh: true},
+	} {
+		_, err = o.args("apply", true)
+		assert.Error(t, err, "%+v", o)
+	}
+
+	_, err = opts.args("destroy", false)
+	assert.Error(t, err)
+
+	_, err = RunOptions{RefreshOnly: true, NoRefresh: true}.args("plan", false)
+	assert.Error(t, err)
+	_, err = RunOptions{RefreshOnly: true, Replace: []string{"a"}}.args("plan", false)
+	assert.Error(t, err)
+
+	args, err = RunOptions{}.args("plan", false)
+	assert.NoError(t, err)
+	assert.Empty(t, args)
+
+	assert.Nil(t, RunOptions{Targets: []string{"a"}}.requiredVersion())
+	assert.Equal(t, minReplaceVersion, RunOptions{Replace: []string{"a"}}.requiredVersion())
+	assert.Equal(t, minRefreshOnlyVersion, RunOptions{RefreshOnly: true, Replace: []string{"a"}}.requiredVersion())
+}
+
+func TestRunOptions(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+if [ "$1" = "version" ]; then
+	echo "{\"terraform_version\": \"$TF_FAKE_VERSION\"}"
+	exit 0
+fi
+echo "$@"
+`)
+	out := &bytes.Buffer{}
+	tf := New(tfbin, t.TempDir())
+	tf.SetStdout(out)
+	tf.WithEnv(map[string]string{"TF_FAKE_VERSION": "0.15.0"})
+
+	must(t, tf.DestroyWithOptions(RunOptions{Targets: []string{"null_resource.a"}}))
+	assert.Contains(t, out.String(), "-target=null_resource.a")
+	// options are not kept for later calls
+	out.Reset()
+	must(t, tf.Destroy())
+	assert.NotContains(t, out.String(), "-target")
+
+	_, err := tf.PlanWithOptions("", RunOptions{RefreshOnly: true})
+	assert.Error(t, err)
+
+	tf = New(tfbin, t.TempDir())
+	tf.SetStdout(out)
+	tf.WithEnv(map[string]string{"TF_FAKE_VERSION": "1.1.6"})
+	out.Reset()
+	must(t, tf.ApplyWithOptions(RunOptions{RefreshOnly: true}))
+	assert.Contains(t, out.String(), "-refresh-only")
+	out.Reset()
+	result, err := tf.PlanWithOptions("", RunOptions{Replace: []string{"null_resource.a"}})
+	if assert.NoError(t, err) {
+		assert.False(t, result.HasChanges)
+	}
+	assert.Contains(t, out.String(), "-replace=null_resource.a")
+	assert.Contains(t, out.String(), "-detailed-exitcode")
+}
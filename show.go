@@ -0,0 +1,247 @@
+package tfcli
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Plan is the JSON representation of a saved plan as returned by 'terraform show -json <planfile>'.
+// See https://www.terraform.io/internals/json-format
+type Plan struct {
+	FormatVersion    string                     `json:"format_version"`
+	TerraformVersion string                     `json:"terraform_version"`
+	Variables        map[string]PlanVariable    `json:"variables,omitempty"`
+	ResourceDrift    []ResourceChange           `json:"resource_drift,omitempty"`
+	ResourceChanges  []ResourceChange           `json:"resource_changes,omitempty"`
+	OutputChanges    map[string]Change          `json:"output_changes,omitempty"`
+	PriorState       *State                     `json:"prior_state,omitempty"`
+	PlannedValues    *StateValues               `json:"planned_values,omitempty"`
+	Configuration    map[string]json.RawMessage `json:"configuration,omitempty"`
+}
+
+// PlanVariable is the value of a root module variable used for the plan
+type PlanVariable struct {
+	Value json.RawMessage `json:"value"`
+}
+
+// ResourceChange describes the planned change of a single resource instance
+type ResourceChange struct {
+	Address         string          `json:"address"`
+	PreviousAddress string          `json:"previous_address,omitempty"`
+	ModuleAddress   string          `json:"module_address,omitempty"`
+	Mode            string          `json:"mode"`
+	Type            string          `json:"type"`
+	Name            string          `json:"name"`
+	Index           json.RawMessage `json:"index,omitempty"`
+	ProviderName    string          `json:"provider_name"`
+	Deposed         string          `json:"deposed,omitempty"`
+	Change          Change          `json:"change"`
+	ActionReason    string          `json:"action_reason,omitempty"`
+}
+
+// Change describes the before and after values of a resource or output
+type Change struct {
+	Actions         Actions         `json:"actions"`
+	Before          json.RawMessage `json:"before"`
+	After           json.RawMessage `json:"after"`
+	AfterUnknown    json.RawMessage `json:"after_unknown,omitempty"`
+	BeforeSensitive json.RawMessage `json:"before_sensitive,omitempty"`
+	AfterSensitive  json.RawMessage `json:"after_sensitive,omitempty"`
+	ReplacePaths    json.RawMessage `json:"replace_paths,omitempty"`
+}
+
+// Actions are the actions of a change, e.g. ["create"] or ["delete", "create"]
+type Actions []string
+
+// Action names
+const (
+	ActionNoOp   = "no-op"
+	ActionCreate = "create"
+	ActionRead   = "read"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// NoOp returns true if nothing changes.
+func (a Actions) NoOp() bool {
+	return a.is(ActionNoOp)
+}
+
+// Create returns true if a new object is created.
+func (a Actions) Create() bool {
+	return a.is(ActionCreate)
+}
+
+// Read returns true if a data source is read.
+func (a Actions) Read() bool {
+	return a.is(ActionRead)
+}
+
+// Update returns true if the object is updated in-place.
+func (a Actions) Update() bool {
+	return a.is(ActionUpdate)
+}
+
+// Delete returns true if the object is deleted without replacement.
+func (a Actions) Delete() bool {
+	return a.is(ActionDelete)
+}
+
+// Replace returns true if the object is deleted and created again.
+func (a Actions) Replace() bool {
+	return a.is(ActionDelete, ActionCreate) || a.is(ActionCreate, ActionDelete)
+}
+
+// Deletes returns true if the existing object is deleted, either by a delete or a replacement.
+func (a Actions) Deletes() bool {
+	for _, action := range a {
+		if action == ActionDelete {
+			return true
+		}
+	}
+	return false
+}
+
+// Changes returns true if the actions modify infrastructure.
+func (a Actions) Changes() bool {
+	return len(a) > 0 && !a.NoOp() && !a.Read()
+}
+
+func (a Actions) is(actions ...string) bool {
+	if len(a) != len(actions) {
+		return false
+	}
+	for i := range a {
+		if a[i] != actions[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// HasChanges returns true if the plan changes any resource or output.
+func (p *Plan) HasChanges() bool {
+	for _, rc := range p.ResourceChanges {
+		if rc.Change.Actions.Changes() {
+			return true
+		}
+	}
+	for _, oc := range p.OutputChanges {
+		if oc.Actions.Changes() {
+			return true
+		}
+	}
+	return false
+}
+
+// Deletions returns all resource changes deleting an object, including replacements.
+func (p *Plan) Deletions() []ResourceChange {
+	deletions := []ResourceChange{}
+	for _, rc := range p.ResourceChanges {
+		if rc.Change.Actions.Deletes() {
+			deletions = append(deletions, rc)
+		}
+	}
+	return deletions
+}
+
+// ChangesByAddress returns the resource changes by resource address.
+func (p *Plan) ChangesByAddress() map[string]ResourceChange {
+	changes := make(map[string]ResourceChange, len(p.ResourceChanges))
+	for _, rc := range p.ResourceChanges {
+		address := rc.Address
+		if rc.Deposed != "" {
+			address = address + " (deposed " + rc.Deposed + ")"
+		}
+		changes[address] = rc
+	}
+	return changes
+}
+
+// State is the JSON representation of the state as returned by 'terraform show -json'.
+type State struct {
+	FormatVersion    string       `json:"format_version"`
+	TerraformVersion string       `json:"terraform_version"`
+	Values           *StateValues `json:"values,omitempty"`
+}
+
+// StateValues contains the outputs and resources of a state or planned state
+type StateValues struct {
+	Outputs    map[string]StateOutput `json:"outputs,omitempty"`
+	RootModule StateModule            `json:"root_module"`
+}
+
+// StateOutput is a single output value of a state
+type StateOutput struct {
+	Sensitive bool            `json:"sensitive"`
+	Value     json.RawMessage `json:"value"`
+	Type      json.RawMessage `json:"type,omitempty"`
+}
+
+// StateModule contains the resources of a module and its child modules
+type StateModule struct {
+	Address      string          `json:"address,omitempty"`
+	Resources    []StateResource `json:"resources,omitempty"`
+	ChildModules []StateModule   `json:"child_modules,omitempty"`
+}
+
+// StateResource is a single resource instance of a state
+type StateResource struct {
+	Address         string          `json:"address"`
+	Mode            string          `json:"mode"`
+	Type            string          `json:"type"`
+	Name            string          `json:"name"`
+	Index           json.RawMessage `json:"index,omitempty"`
+	ProviderName    string          `json:"provider_name"`
+	SchemaVersion   int             `json:"schema_version"`
+	Values          json.RawMessage `json:"values,omitempty"`
+	SensitiveValues json.RawMessage `json:"sensitive_values,omitempty"`
+	DependsOn       []string        `json:"depends_on,omitempty"`
+	Tainted         bool            `json:"tainted,omitempty"`
+	DeposedKey      string          `json:"deposed_key,omitempty"`
+}
+
+// Resources returns the resources of all modules of the state.
+func (s *State) Resources() []StateResource {
+	if s.Values == nil {
+		return []StateResource{}
+	}
+	return s.Values.RootModule.AllResources()
+}
+
+// Resource returns the resource with the given address.
+func (s *State) Resource(address string) (StateResource, bool) {
+	for _, r := range s.Resources() {
+		if r.Address == address {
+			return r, true
+		}
+	}
+	return StateResource{}, false
+}
+
+// AllResources returns the resources of the module and all its child modules.
+func (m StateModule) AllResources() []StateResource {
+	resources := append([]StateResource{}, m.Resources...)
+	for _, child := range m.ChildModules {
+		resources = append(resources, child.AllResources()...)
+	}
+	return resources
+}
+
+func readState(raw []byte) (*State, error) {
+	state := &State{}
+	err := json.Unmarshal(raw, state)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode terraform state. Original error: %s", err)
+	}
+	return state, nil
+}
+
+func readPlan(raw []byte) (*Plan, error) {
+	plan := &Plan{}
+	err := json.Unmarshal(raw, plan)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode terraform plan. Original error: %s", err)
+	}
+	return plan, nil
+}
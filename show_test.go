@@ -0,0 +1,167 @@
+package tfcli
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const recordedPlan = `{
+	"format_version": "1.0",
+	"terraform_version": "1.1.6",
+	"variables": {"name": {"value": "db"}},
+	"resource_drift": [
+		{
+			"address": "aws_instance.web",
+			"mode": "managed",
+			"type": "aws_instance",
+			"name": "web",
+			"provider_name": "registry.terraform.io/hashicorp/aws",
+			"change": {"actions": ["update"], "before": {"instance_type": "t2.micro"}, "after": {"instance_type": "t2.small"}}
+		}
+	],
+	"resource_changes": [
+		{
+			"address": "aws_db_instance.main",
+			"mode": "managed",
+			"type": "aws_db_instance",
+			"name": "main",
+			"provider_name": "registry.terraform.io/hashicorp/aws",
+			"change": {"actions": ["delete"], "before": {"identifier": "main"}, "after": null},
+			"action_reason": "delete_because_no_resource_config"
+		},
+		{
+			"address": "aws_instance.web",
+			"mode": "managed",
+			"type": "aws_instance",
+			"name": "web",
+			"provider_name": "registry.terraform.io/hashicorp/aws",
+			"change": {"actions": ["delete", "create"], "before": {"ami": "ami-1"}, "after": {"ami": "ami-2"}, "replace_paths": [["ami"]]}
+		},
+		{
+			"address": "aws_s3_bucket.logs[\"eu\"]",
+			"mode": "managed",
+			"type": "aws_s3_bucket",
+			"name": "logs",
+			"index": "eu",
+			"provider_name": "registry.terraform.io/hashicorp/aws",
+			"change": {"actions": ["no-op"], "before": {"bucket": "logs"}, "after": {"bucket": "logs"}}
+		}
+	],
+	"output_changes": {
+		"endpoint": {"actions": ["no-op"], "before": "db.example.com", "after": "db.example.com"}
+	},
+	"prior_state": {
+		"format_version": "1.0",
+		"terraform_version": "1.1.6",
+		"values": {
+			"root_module": {
+				"resources": [
+					{
+						"address": "aws_db_instance.main",
+						"mode": "managed",
+						"type": "aws_db_instance",
+						"name": "main",
+						"provider_name": "registry.terraform.io/hashicorp/aws",
+						"schema_version": 1,
+						"values": {"identifier": "main"}
+					}
+				]
+			}
+		}
+	}
+}`
+
+func TestReadPlan(t *testing.T) {
+	plan, err := readPlan([]byte(recordedPlan))
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "readPlan failed")
+	}
+	assert.Equal(t, "1.1.6", plan.TerraformVersion)
+	assert.True(t, plan.HasChanges())
+	assert.Len(t, plan.ResourceDrift, 1)
+
+	deletions := plan.Deletions()
+	if assert.Len(t, deletions, 2) {
+		assert.Equal(t, "aws_db_instance.main", deletions[0].Address)
+		assert.True(t, deletions[0].Change.Actions.Delete())
+		assert.True(t, deletions[1].Change.Actions.Replace())
+		assert.False(t, deletions[1].Change.Actions.Delete())
+	}
+
+	changes := plan.ChangesByAddress()
+	assert.Len(t, changes, 3)
+	assert.True(t, changes[`aws_s3_bucket.logs["eu"]`].Change.Actions.NoOp())
+	assert.JSONEq(t, `{"ami": "ami-2"}`, string(changes["aws_instance.web"].Change.After))
+
+	assert.Equal(t, "aws_db_instance.main", plan.PriorState.Values.RootModule.Resources[0].Address)
+
+	noop := &Plan{ResourceChanges: []ResourceChange{changes[`aws_s3_bucket.logs["eu"]`]}}
+	assert.False(t, noop.HasChanges())
+	assert.Empty(t, noop.Deletions())
+}
+
+func TestShowPlan(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+if [ "$1" != "show" ]; then
+	exit 1
+fi
+cat <<'JSON'
+`+recordedPlan+`
+JSON
+`)
+	tmpDir := t.TempDir()
+	planfile := filepath.Join(tmpDir, "my.tfplan")
+	must(t, ioutil.WriteFile(planfile, []byte{}, 0644))
+	tf := New(tfbin, tmpDir)
+
+	plan, err := tf.ShowPlan("my.tfplan")
+	if assert.NoError(t, err) {
+		assert.Len(t, plan.ResourceChanges, 3)
+	}
+
+	_, err = tf.ShowPlan("missing.tfplan")
+	assert.Error(t, err)
+}
+
+func TestShowState(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+cat <<'JSON'
+{
+	"format_version": "1.0",
+	"terraform_version": "1.1.6",
+	"values": {
+		"outputs": {"id": {"sensitive": false, "value": "1234", "type": "string"}},
+		"root_module": {
+			"resources": [
+				{"address": "null_resource.test", "mode": "managed", "type": "null_resource", "name": "test", "provider_name": "registry.terraform.io/hashicorp/null", "values": {"id": "1234"}}
+			],
+			"child_modules": [
+				{
+					"address": "module.child",
+					"resources": [
+						{"address": "module.child.null_resource.test", "mode": "managed", "type": "null_resource", "name": "test", "provider_name": "registry.terraform.io/hashicorp/null", "values": {"id": "5678"}}
+					]
+				}
+			]
+		}
+	}
+}
+JSON
+`)
+	tf := New(tfbin, t.TempDir())
+	state, err := tf.ShowState()
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "show failed")
+	}
+	assert.Len(t, state.Resources(), 2)
+	resource, ok := state.Resource("module.child.null_resource.test")
+	if assert.True(t, ok) {
+		assert.JSONEq(t, `{"id": "5678"}`, string(resource.Values))
+	}
+	_, ok = state.Resource("missing")
+	assert.False(t, ok)
+	assert.Empty(t, (&State{}).Resources())
+}
@@ -0,0 +1,260 @@
+package tfcli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// StateFile is the raw terraform state as returned by 'terraform state pull'.
+type StateFile struct {
+	Version          int                        `json:"version"`
+	TerraformVersion string                     `json:"terraform_version"`
+	Serial           int64                      `json:"serial"`
+	Lineage          string                     `json:"lineage"`
+	Outputs          map[string]StateFileOutput `json:"outputs"`
+	Resources        []StateFileResource        `json:"resources"`
+}
+
+// StateFileOutput is an output value stored in the state
+type StateFileOutput struct {
+	Value     json.RawMessage `json:"value"`
+	Type      json.RawMessage `json:"type"`
+	Sensitive bool            `json:"sensitive,omitempty"`
+}
+
+// StateFileResource is a resource stored in the state
+type StateFileResource struct {
+	Module    string              `json:"module,omitempty"`
+	Mode      string              `json:"mode"`
+	Type      string              `json:"type"`
+	Name      string              `json:"name"`
+	Each      string              `json:"each,omitempty"`
+	Provider  string              `json:"provider"`
+	Instances []StateFileInstance `json:"instances"`
+}
+
+// StateFileInstance is a single instance of a resource stored in the state
+type StateFileInstance struct {
+	IndexKey            json.RawMessage `json:"index_key,omitempty"`
+	Status              string          `json:"status,omitempty"`
+	Deposed             string          `json:"deposed,omitempty"`
+	SchemaVersion       int             `json:"schema_version"`
+	Attributes          json.RawMessage `json:"attributes,omitempty"`
+	SensitiveAttributes json.RawMessage `json:"sensitive_attributes,omitempty"`
+	Private             string          `json:"private,omitempty"`
+	Dependencies        []string        `json:"dependencies,omitempty"`
+	CreateBeforeDestroy bool            `json:"create_before_destroy,omitempty"`
+}
+
+// StateList returns the addresses of the resources in the state.
+// The optional filter addresses limit the result to matching resources.
+func (t *terraform) StateList(filter ...string) ([]string, error) {
+	return t.StateListContext(context.Background(), filter...)
+}
+
+func (t *terraform) StateListContext(ctx context.Context, filter ...string) ([]string, error) {
+	cmd := t.newCommand([]string{"state", "list"}, filter)
+	buffer := bytes.Buffer{}
+	cmd.Stdout = &buffer
+	err := t.run(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return readLines(buffer.Bytes()), nil
+}
+
+// StateShow returns the human readable attributes of the resource with the given address.
+func (t *terraform) StateShow(address string) (string, error) {
+	return t.StateShowContext(context.Background(), address)
+}
+
+func (t *terraform) StateShowContext(ctx context.Context, address string) (string, error) {
+	cmd := t.newCommand([]string{"state", "show", "-no-color", address})
+	buffer := bytes.Buffer{}
+	cmd.Stdout = &buffer
+	err := t.run(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// StatePull returns the parsed state from the configured backend.
+func (t *terraform) StatePull() (*StateFile, error) {
+	return t.StatePullContext(context.Background())
+}
+
+func (t *terraform) StatePullContext(ctx context.Context) (*StateFile, error) {
+	raw, err := t.statePull(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return readStateFile(raw)
+}
+
+func (t *terraform) statePull(ctx context.Context) ([]byte, error) {
+	cmd := t.newCommand([]string{"state", "pull"})
+	buffer := bytes.Buffer{}
+	cmd.Stdout = &buffer
+	err := t.run(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// StateMove is a single move of a batch state move
+type StateMove struct {
+	Source      string
+	Destination string
+}
+
+// StateMv moves the item with the source address to the destination address.
+func (t *terraform) StateMv(src, dst string) error {
+	return t.StateMvContext(context.Background(), src, dst)
+}
+
+func (t *terraform) StateMvContext(ctx context.Context, src, dst string) error {
+	cmd := t.newCommand([]string{"state", "mv", src, dst})
+	return t.run(ctx, cmd)
+}
+
+// StateMvAll applies the moves in order. The state is pulled before and
+// pushed back if one of the moves fails after others succeeded. If the context
+// is done, the state is restored within the grace period.
+func (t *terraform) StateMvAll(moves []StateMove) error {
+	return t.StateMvAllContext(context.Background(), moves)
+}
+
+func (t *terraform) StateMvAllContext(ctx context.Context, moves []StateMove) error {
+	backup, err := t.statePull(ctx)
+	if err != nil {
+		return fmt.Errorf("cannot backup state: %w", err)
+	}
+	if len(moves) > 0 && len(bytes.TrimSpace(backup)) == 0 {
+		// an empty state cannot be pushed back, nothing could be moved anyway
+		return fmt.Errorf("cannot move '%s' to '%s': no state exists", moves[0].Source, moves[0].Destination)
+	}
+	for i, move := range moves {
+		err := t.StateMvContext(ctx, move.Source, move.Destination)
+		if err == nil {
+			continue
+		}
+		if i == 0 {
+			// nothing was changed, e.g. the state is locked by another run
+			return fmt.Errorf("state move '%s' to '%s' failed: %w", move.Source, move.Destination, err)
+		}
+		rollbackErr := t.restoreState(ctx, backup)
+		if rollbackErr != nil {
+			return fmt.Errorf("state move '%s' to '%s' failed and the state could not be restored: %s. Original error: %w", move.Source, move.Destination, rollbackErr, err)
+		}
+		return fmt.Errorf("state move '%s' to '%s' failed, the state was restored: %w", move.Source, move.Destination, err)
+	}
+	return nil
+}
+
+// restoreState pushes the backup as successor of the current state. The push is not forced,
+// terraform rejects it if the lineage changed or another process wrote a newer state meanwhile.
+// If the context is done already, the restore gets the grace period.
+func (t *terraform) restoreState(ctx context.Context, backup []byte) error {
+	if ctx.Err() != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), t.gracePeriod)
+		defer cancel()
+	}
+	raw, err := t.statePull(ctx)
+	if err != nil {
+		return err
+	}
+	current, err := readStateFile(raw)
+	if err != nil {
+		return err
+	}
+	restored, err := setStateSerial(backup, current.Serial+1)
+	if err != nil {
+		return err
+	}
+	return t.StatePushContext(ctx, bytes.NewReader(restored), false)
+}
+
+// setStateSerial returns the state with the given serial, all other fields are kept as they are
+func setStateSerial(raw []byte, serial int64) ([]byte, error) {
+	state := map[string]json.RawMessage{}
+	err := json.Unmarshal(raw, &state)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode terraform state. Original error: %s", err)
+	}
+	state["serial"] = json.RawMessage(strconv.FormatInt(serial, 10))
+	return json.MarshalIndent(state, "", "  ")
+}
+
+// StateRm removes the items with the given addresses from the state.
+func (t *terraform) StateRm(addresses ...string) error {
+	return t.StateRmContext(context.Background(), addresses...)
+}
+
+func (t *terraform) StateRmContext(ctx context.Context, addresses ...string) error {
+	if len(addresses) == 0 {
+		return fmt.Errorf("at least one address must be given to remove from state")
+	}
+	cmd := t.newCommand([]string{"state", "rm"}, addresses)
+	return t.run(ctx, cmd)
+}
+
+// StatePush overwrites the state with the given state. Force skips the
+// lineage and serial checks.
+func (t *terraform) StatePush(state io.Reader, force bool) error {
+	return t.StatePushContext(context.Background(), state, force)
+}
+
+func (t *terraform) StatePushContext(ctx context.Context, state io.Reader, force bool) error {
+	args := []string{"state", "push"}
+	if force {
+		args = append(args, "-force")
+	}
+	cmd := t.newCommand(args, []string{"-"})
+	cmd.Stdin = state
+	return t.run(ctx, cmd)
+}
+
+// StateReplaceProvider replaces the provider of all resources in the state.
+func (t *terraform) StateReplaceProvider(from, to string) error {
+	return t.StateReplaceProviderContext(context.Background(), from, to)
+}
+
+func (t *terraform) StateReplaceProviderContext(ctx context.Context, from, to string) error {
+	cmd := t.newCommand([]string{"state", "replace-provider", "-auto-approve", from, to})
+	return t.run(ctx, cmd)
+}
+
+func readStateFile(raw []byte) (*StateFile, error) {
+	state := &StateFile{}
+	if len(bytes.TrimSpace(raw)) == 0 {
+		// no state exists yet
+		return state, nil
+	}
+	err := json.Unmarshal(raw, state)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode terraform state. Original error: %s", err)
+	}
+	return state, nil
+}
+
+// readLines returns the non empty lines of the output
+func readLines(raw []byte) []string {
+	lines := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,242 @@
+package tfcli
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const recordedStateFile = `{
+	"version": 4,
+	"terraform_version": "1.1.6",
+	"serial": 3,
+	"lineage": "4d5e6f7a-1b2c-3d4e-5f6a-7b8c9d0e1f2a",
+	"outputs": {
+		"id": {"value": "1234", "type": "string"}
+	},
+	"resources": [
+		{
+			"mode": "managed",
+			"type": "null_resource",
+			"name": "test",
+			"provider": "provider[\"registry.terraform.io/hashicorp/null\"]",
+			"instances": [
+				{"schema_version": 0, "attributes": {"id": "1234", "triggers": null}, "sensitive_attributes": []}
+			]
+		},
+		{
+			"module": "module.child",
+			"mode": "managed",
+			"type": "null_resource",
+			"name": "each",
+			"each": "map",
+			"provider": "provider[\"registry.terraform.io/hashicorp/null\"]",
+			"instances": [
+				{"index_key": "a", "schema_version": 0, "attributes": {"id": "1"}},
+				{"index_key": "b", "schema_version": 0, "attributes": {"id": "2"}}
+			]
+		}
+	]
+}`
+
+func stateTerraform(t *testing.T) Terraform {
+	tf, _ := recordingTerraform(t, `
+case "$1 $2" in
+"state list")
+	shift 2
+	if [ -n "$1" ]; then
+		echo "$1"
+		exit 0
+	fi
+	echo "null_resource.test"
+	echo 'module.child.null_resource.each["a"]'
+	echo 'module.child.null_resource.each["b"]'
+	;;
+"state show")
+	echo "# $4:"
+	echo "resource \"null_resource\" \"test\" {"
+	echo "    id = \"1234\""
+	echo "}"
+	;;
+"state pull")
+	cat <<'JSON'
+`+recordedStateFile+`
+JSON
+	;;
+*)
+	exit 1
+	;;
+esac
+`)
+	return tf
+}
+
+func TestStateList(t *testing.T) {
+	tf := stateTerraform(t)
+	addresses, err := tf.StateList()
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{
+			"null_resource.test",
+			`module.child.null_resource.each["a"]`,
+			`module.child.null_resource.each["b"]`,
+		}, addresses)
+	}
+	addresses, err = tf.StateList("null_resource.test")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"null_resource.test"}, addresses)
+	}
+}
+
+func TestStateShow(t *testing.T) {
+	tf := stateTerraform(t)
+	out, err := tf.StateShow("null_resource.test")
+	if assert.NoError(t, err) {
+		assert.Contains(t, out, "# null_resource.test:")
+		assert.Contains(t, out, `id = "1234"`)
+	}
+}
+
+func TestStatePull(t *testing.T) {
+	tf := stateTerraform(t)
+	state, err := tf.StatePull()
+	if !assert.NoError(t, err) {
+		assert.FailNow(t, "state pull failed")
+	}
+	assert.Equal(t, 4, state.Version)
+	assert.Equal(t, int64(3), state.Serial)
+	assert.Equal(t, "4d5e6f7a-1b2c-3d4e-5f6a-7b8c9d0e1f2a", state.Lineage)
+	assert.Len(t, state.Resources, 2)
+	assert.Equal(t, "module.child", state.Resources[1].Module)
+	assert.Len(t, state.Resources[1].Instances, 2)
+	assert.Equal(t, `"b"`, string(state.Resources[1].Instances[1].IndexKey))
+	assert.JSONEq(t, `{"id": "1234", "triggers": null}`, string(state.Resources[0].Instances[0].Attributes))
+
+	empty, err := readStateFile([]byte("\n"))
+	if assert.NoError(t, err) {
+		assert.Empty(t, empty.Resources)
+	}
+}
+
+func mutationTerraform(t *testing.T) (Terraform, string) {
+	return recordingTerraform(t, `
+case "$1 $2" in
+"state pull")
+	echo "{\"version\": 4, \"serial\": $(cat serial 2>/dev/null || echo 1)}"
+	;;
+"state push")
+	cat > pushed.tfstate
+	;;
+"state mv")
+	if [ "$3" = "fail" ]; then
+		exit 1
+	fi
+	if [ "$3" = "locked" ]; then
+		echo "Error: Error acquiring the state lock" >&2
+		exit 1
+	fi
+	if [ "$3" = "slow" ]; then
+		sleep 5
+	fi
+	echo $(( $(cat serial 2>/dev/null || echo 1) + 1 )) > serial
+	;;
+esac
+`)
+}
+
+func TestStateMutations(t *testing.T) {
+	tf, dir := mutationTerraform(t)
+	must(t, tf.StateMv("null_resource.a", "null_resource.b"))
+	must(t, tf.StateRm("null_resource.a", "null_resource.b"))
+	assert.Error(t, tf.StateRm())
+	must(t, tf.StateReplaceProvider("hashicorp/aws", "registry.example.com/acme/aws"))
+	must(t, tf.StatePush(strings.NewReader(`{"version": 4}`), true))
+
+	assert.Equal(t, []string{
+		"state mv null_resource.a null_resource.b",
+		"state rm null_resource.a null_resource.b",
+		"state replace-provider -auto-approve hashicorp/aws registry.example.com/acme/aws",
+		"state push -force -",
+	}, readCalls(t, dir))
+	pushed, err := ioutil.ReadFile(filepath.Join(dir, "pushed.tfstate"))
+	must(t, err)
+	assert.Equal(t, `{"version": 4}`, string(pushed))
+}
+
+func TestStateMvAll(t *testing.T) {
+	tf, dir := mutationTerraform(t)
+	err := tf.StateMvAll([]StateMove{
+		{Source: "a", Destination: "b"},
+		{Source: "c", Destination: "d"},
+	})
+	must(t, err)
+	assert.Equal(t, []string{"state pull", "state mv a b", "state mv c d"}, readCalls(t, dir))
+
+	tf, dir = mutationTerraform(t)
+	err = tf.StateMvAll([]StateMove{
+		{Source: "a", Destination: "b"},
+		{Source: "fail", Destination: "d"},
+		{Source: "e", Destination: "f"},
+	})
+	var cmdErr *CommandError
+	assert.ErrorAs(t, err, &cmdErr)
+	assert.Equal(t, []string{"state pull", "state mv a b", "state mv fail d", "state pull", "state push -"}, readCalls(t, dir))
+	pushed, err := ioutil.ReadFile(filepath.Join(dir, "pushed.tfstate"))
+	must(t, err)
+	// the backup is pushed as successor of the current state
+	assert.JSONEq(t, `{"version": 4, "serial": 3}`, string(pushed))
+}
+
+func TestStateMvAllFirstMoveFails(t *testing.T) {
+	for _, src := range []string{"fail", "locked"} {
+		tf, dir := mutationTerraform(t)
+		err := tf.StateMvAll([]StateMove{
+			{Source: src, Destination: "b"},
+			{Source: "c", Destination: "d"},
+		})
+		assert.Error(t, err, src)
+		assert.NotContains(t, err.Error(), "restored", src)
+		assert.Equal(t, []string{"state pull", "state mv " + src + " b"}, readCalls(t, dir), src)
+		assert.NoFileExists(t, filepath.Join(dir, "pushed.tfstate"), src)
+	}
+	tf, _ := mutationTerraform(t)
+	err := tf.StateMvAll([]StateMove{{Source: "locked", Destination: "b"}})
+	assert.True(t, IsStateLockError(err))
+}
+
+func TestSetStateSerial(t *testing.T) {
+	raw, err := setStateSerial([]byte(recordedStateFile), 7)
+	must(t, err)
+	state, err := readStateFile(raw)
+	must(t, err)
+	assert.Equal(t, int64(7), state.Serial)
+	assert.Equal(t, "4d5e6f7a-1b2c-3d4e-5f6a-7b8c9d0e1f2a", state.Lineage)
+	assert.Len(t, state.Resources, 2)
+}
+
+func TestStateMvAllContext(t *testing.T) {
+	tf, dir := mutationTerraform(t)
+	tf.SetGracePeriod(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err := tf.StateMvAllContext(ctx, []StateMove{
+		{Source: "a", Destination: "b"},
+		{Source: "slow", Destination: "d"},
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Contains(t, err.Error(), "the state was restored")
+	assert.Equal(t, []string{"state pull", "state mv a b", "state mv slow d", "state pull", "state push -"}, readCalls(t, dir))
+}
+
+func TestStateMvAllNoState(t *testing.T) {
+	tf, dir := recordingTerraform(t, "")
+	err := tf.StateMvAll([]StateMove{
+		{Source: "a", Destination: "b"},
+	})
+	assert.ErrorContains(t, err, "no state exists")
+	assert.Equal(t, []string{"state pull"}, readCalls(t, dir))
+}
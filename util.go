@@ -1,16 +1,12 @@
 package tfcli
 
 import (
-	"bytes"
-	"context"
-	"fmt"
-	"html/template"
-	"io/ioutil"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
-
-	getter "github.com/hashicorp/go-getter"
+	"strings"
+	"sync"
 )
 
 // mergeStringArrays merges a list of string arrays into one string array
@@ -35,66 +31,71 @@ func mapToArgs(params map[string]string, optionName string) []string {
 }
 
 // DownloadTerraform downloads the terraform binary for the given version from the official source.
+// The version can also be a constraint like '~> 1.5'; the newest matching stable version is used.
 func DownloadTerraform(version string, force bool) (string, error) {
-	userDir, err := os.UserHomeDir()
-	if err != nil {
-		return "", err
-	}
-	tfbaseDir := filepath.Join(userDir, ".tf", "cache", "terraform")
-	return downloadTerraform(tfbaseDir, version, force)
+	return NewDownloader().Download(version, force)
 }
 
 func downloadTerraform(dir, version string, force bool) (string, error) {
-	url, err := terraformDownloadURL(version)
-	if err != nil {
-		return "", err
-	}
-	file := filepath.Join(dir, version)
+	return NewDownloader().SetCacheDir(dir).Download(version, force)
+}
 
-	tffile := filepath.Join(file, "terraform")
-	if runtime.GOOS == "windows" {
-		tffile = filepath.Join(file, "terraform.exe")
-	}
+func terraformDownloadURL(version string) (string, error) {
+	return releaseFileURL(defaultReleasesURL, version, releaseZipName(version, runtime.GOOS, runtime.GOARCH)), nil
+}
 
-	if fileExists(tffile) && !force {
-		return tffile, nil
-	}
-	opts := []getter.ClientOption{}
-	client := &getter.Client{
-		Ctx:     context.Background(),
-		Src:     url,
-		Dst:     file,
-		Mode:    getter.ClientModeAny,
-		Options: opts,
+// isSubPath returns true if path is located in the parent directory
+func isSubPath(parent, path string) bool {
+	parent, err := filepath.Abs(parent)
+	if err != nil {
+		return false
 	}
-	err = client.Get()
+	path, err = filepath.Abs(path)
 	if err != nil {
-		return "", err
+		return false
 	}
+	rel, err := filepath.Rel(parent, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
 
-	if !fileExists(tffile) {
-		f, _ := ioutil.ReadDir(file)
-		list := []string{}
-		for _, e := range f {
-			list = append(list, e.Name())
+// copyDir copies the content of the src directory into the dst directory.
+// The '.terraform' directory of a module is skipped.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
-		return "", fmt.Errorf("Terraform executable not found: %s, Content: %+v", tffile, list)
-	}
-	return tffile, nil
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".terraform" {
+			return filepath.SkipDir
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		return copyFile(path, target, info.Mode().Perm())
+	})
 }
 
-func terraformDownloadURL(version string) (string, error) {
-	var b bytes.Buffer
-	tpl, err := template.New("").Parse("https://releases.hashicorp.com/terraform/{{.version}}/terraform_{{.version}}_{{.goos}}_{{.goarch}}.zip")
+func copyFile(src, dst string, mode os.FileMode) error {
+	in, err := os.Open(src)
 	if err != nil {
-		return "", err
+		return err
 	}
-	err = tpl.Execute(&b, map[string]string{
-		"version": version,
-		"goos":    runtime.GOOS,
-		"goarch":  runtime.GOARCH,
-	})
-	return b.String(), err
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
 }
 
 func fileExists(filename string) bool {
@@ -104,3 +105,31 @@ func fileExists(filename string) bool {
 	}
 	return !info.IsDir()
 }
+
+// tailWriter keeps the last bytes written to it.
+// It is safe to read while the command is still writing.
+type tailWriter struct {
+	mu   sync.Mutex
+	size int
+	buf  []byte
+}
+
+func newTailWriter(size int) *tailWriter {
+	return &tailWriter{size: size}
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.size {
+		w.buf = w.buf[len(w.buf)-w.size:]
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return string(w.buf)
+}
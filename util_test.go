@@ -69,3 +69,11 @@ func TestMapToArgs(t *testing.T) {
 	res = mapToArgs(nil, "var")
 	assert.Equal(t, 0, len(res))
 }
+
+func TestTailWriter(t *testing.T) {
+	w := newTailWriter(5)
+	w.Write([]byte("abc"))
+	assert.Equal(t, "abc", w.String())
+	w.Write([]byte("defgh"))
+	assert.Equal(t, "defgh", w.String())
+}
@@ -0,0 +1,98 @@
+package tfcli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ValidateResult is the result of 'terraform validate -json'
+type ValidateResult struct {
+	FormatVersion string       `json:"format_version"`
+	Valid         bool         `json:"valid"`
+	ErrorCount    int          `json:"error_count"`
+	WarningCount  int          `json:"warning_count"`
+	Diagnostics   []Diagnostic `json:"diagnostics"`
+}
+
+// FmtResult is the result of 'terraform fmt'
+type FmtResult struct {
+	// Files are the files which need formatting (check) or were formatted
+	Files []string
+}
+
+// Validate validates the configuration of the working directory.
+// An invalid configuration is not an error, see ValidateResult.Valid
+func (t *terraform) Validate() (*ValidateResult, error) {
+	return t.ValidateContext(context.Background())
+}
+
+func (t *terraform) ValidateContext(ctx context.Context) (*ValidateResult, error) {
+	cmd := t.newCommand([]string{"validate", "-no-color", "-json"})
+	buffer := bytes.Buffer{}
+	cmd.Stdout = &buffer
+	err := t.run(ctx, cmd)
+	// terraform exits with 1 if the configuration is invalid
+	var cmdErr *CommandError
+	if err != nil && !(errors.As(err, &cmdErr) && cmdErr.ExitCode == 1 && buffer.Len() > 0) {
+		return nil, err
+	}
+	res := &ValidateResult{}
+	jsonErr := json.Unmarshal(buffer.Bytes(), res)
+	if jsonErr != nil {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("unable to decode terraform validate result. Original error: %s", jsonErr)
+	}
+	return res, nil
+}
+
+// Fmt formats the configuration files of the working directory and returns
+// the changed files. With check the files are not changed, but only listed.
+func (t *terraform) Fmt(check bool, recursive bool) (*FmtResult, error) {
+	return t.FmtContext(context.Background(), check, recursive)
+}
+
+func (t *terraform) FmtContext(ctx context.Context, check bool, recursive bool) (*FmtResult, error) {
+	args := []string{"fmt", "-no-color", "-list=true"}
+	if check {
+		args = append(args, "-check")
+	}
+	out, err := t.fmt(ctx, args, recursive)
+	if err != nil {
+		return nil, err
+	}
+	return &FmtResult{Files: readLines(out)}, nil
+}
+
+// FmtDiff returns the diff of the formatting changes without changing any files.
+func (t *terraform) FmtDiff(recursive bool) (string, error) {
+	return t.FmtDiffContext(context.Background(), recursive)
+}
+
+func (t *terraform) FmtDiffContext(ctx context.Context, recursive bool) (string, error) {
+	out, err := t.fmt(ctx, []string{"fmt", "-no-color", "-list=false", "-check", "-diff"}, recursive)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (t *terraform) fmt(ctx context.Context, args []string, recursive bool) ([]byte, error) {
+	if recursive {
+		args = append(args, "-recursive")
+	}
+	cmd := t.newCommand(args)
+	buffer := bytes.Buffer{}
+	cmd.Stdout = &buffer
+	err := t.run(ctx, cmd)
+	// terraform exits with 3 if check is enabled and files are not formatted
+	var cmdErr *CommandError
+	if err != nil && !(errors.As(err, &cmdErr) && cmdErr.ExitCode == 3) {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+package tfcli
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate(t *testing.T) {
+	tfbin := fakeTerraform(t, `
+if [ "$VALID" = "true" ]; then
+	echo '{"format_version": "1.0", "valid": true, "error_count": 0, "warning_count": 0, "diagnostics": []}'
+	exit 0
+fi
+if [ "$VALID" = "crash" ]; then
+	echo "Error: Failed to load plugin schemas" >&2
+	exit 1
+fi
+cat <<'JSON'
+{
+	"format_version": "1.0",
+	"valid": false,
+	"error_count": 1,
+	"warning_count": 0,
+	"diagnostics": [
+		{
+			"severity": "error",
+			"summary": "Reference to undeclared input variable",
+			"detail": "An input variable with the name \"missing\" has not been declared.",
+			"range": {
+				"filename": "main.tf",
+				"start": {"line": 12, "column": 11, "byte": 180},
+				"end": {"line": 12, "column": 22, "byte": 191}
+			},
+			"snippet": {"context": "output \"x\"", "code": "  value = var.missing", "start_line": 12}
+		}
+	]
+}
+JSON
+exit 1
+`)
+	tf := New(tfbin, t.TempDir())
+
+	tf.WithEnv(map[string]string{"VALID": "true"})
+	res, err := tf.Validate()
+	if assert.NoError(t, err) {
+		assert.True(t, res.Valid)
+		assert.Empty(t, res.Diagnostics)
+	}
+
+	tf.WithEnv(map[string]string{"VALID": "false"})
+	res, err = tf.Validate()
+	if assert.NoError(t, err) {
+		assert.False(t, res.Valid)
+		assert.Equal(t, 1, res.ErrorCount)
+		if assert.Len(t, res.Diagnostics, 1) {
+			d := res.Diagnostics[0]
+			assert.Equal(t, "error", d.Severity)
+			assert.Equal(t, "Reference to undeclared input variable", d.Summary)
+			assert.Equal(t, "main.tf", d.Range.Filename)
+			assert.Equal(t, 12, d.Range.Start.Line)
+			assert.Equal(t, 22, d.Range.End.Column)
+		}
+	}
+
+	tf.WithEnv(map[string]string{"VALID": "crash"})
+	_, err = tf.Validate()
+	var cmdErr *CommandError
+	assert.ErrorAs(t, err, &cmdErr)
+}
+
+func TestFmt(t *testing.T) {
+	tf, dir := recordingTerraform(t, `
+case "$*" in
+*-diff*)
+	echo "--- old/main.tf"
+	echo "+++ new/main.tf"
+	exit 3
+	;;
+*-check*)
+	echo "main.tf"
+	echo "modules/vpc/main.tf"
+	exit 3
+	;;
+*)
+	echo "main.tf"
+	;;
+esac
+`)
+
+	res, err := tf.Fmt(true, true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"main.tf", "modules/vpc/main.tf"}, res.Files)
+	}
+	res, err = tf.Fmt(false, false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"main.tf"}, res.Files)
+	}
+	diff, err := tf.FmtDiff(false)
+	if assert.NoError(t, err) {
+		assert.Contains(t, diff, "+++ new/main.tf")
+	}
+	assert.Equal(t, []string{
+		"fmt -no-color -list=true -check -recursive",
+		"fmt -no-color -list=true",
+		"fmt -no-color -list=false -check -diff",
+	}, readCalls(t, dir))
+}
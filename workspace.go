@@ -0,0 +1,116 @@
+package tfcli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// workspaceEnv is the environment variable which overrides the selected workspace
+const workspaceEnv = "TF_WORKSPACE"
+
+// WorkspaceList returns the names of all workspaces.
+func (t *terraform) WorkspaceList() ([]string, error) {
+	return t.WorkspaceListContext(context.Background())
+}
+
+func (t *terraform) WorkspaceListContext(ctx context.Context) ([]string, error) {
+	cmd := t.newCommand([]string{"workspace", "list"})
+	buffer := bytes.Buffer{}
+	cmd.Stdout = &buffer
+	err := t.run(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	workspaces := []string{}
+	for _, line := range readLines(buffer.Bytes()) {
+		// the current workspace is marked with '*'
+		workspaces = append(workspaces, strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	}
+	return workspaces, nil
+}
+
+// WorkspaceShow returns the name of the current workspace.
+func (t *terraform) WorkspaceShow() (string, error) {
+	return t.WorkspaceShowContext(context.Background())
+}
+
+func (t *terraform) WorkspaceShowContext(ctx context.Context) (string, error) {
+	cmd := t.newCommand([]string{"workspace", "show"})
+	buffer := bytes.Buffer{}
+	cmd.Stdout = &buffer
+	err := t.run(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buffer.String()), nil
+}
+
+// WorkspaceNew creates a new workspace and selects it.
+func (t *terraform) WorkspaceNew(name string) error {
+	return t.WorkspaceNewContext(context.Background(), name)
+}
+
+func (t *terraform) WorkspaceNewContext(ctx context.Context, name string) error {
+	cmd := t.newCommand([]string{"workspace", "new", "-no-color", name})
+	return t.run(ctx, cmd)
+}
+
+// WorkspaceSelect selects an existing workspace.
+func (t *terraform) WorkspaceSelect(name string) error {
+	return t.WorkspaceSelectContext(context.Background(), name)
+}
+
+func (t *terraform) WorkspaceSelectContext(ctx context.Context, name string) error {
+	cmd := t.newCommand([]string{"workspace", "select", "-no-color", name})
+	return t.run(ctx, cmd)
+}
+
+// WorkspaceDelete deletes the workspace. Force deletes the workspace even if
+// its state still tracks resources.
+func (t *terraform) WorkspaceDelete(name string, force bool) error {
+	return t.WorkspaceDeleteContext(context.Background(), name, force)
+}
+
+func (t *terraform) WorkspaceDeleteContext(ctx context.Context, name string, force bool) error {
+	args := []string{"workspace", "delete", "-no-color"}
+	if force {
+		args = append(args, "-force")
+	}
+	cmd := t.newCommand(args, []string{name})
+	return t.run(ctx, cmd)
+}
+
+// SelectOrCreateWorkspace selects the workspace and creates it if it does not exist.
+// It fails if the workspace is overridden by the TF_WORKSPACE environment variable,
+// terraform does not allow to select a workspace then.
+func (t *terraform) SelectOrCreateWorkspace(name string) error {
+	return t.SelectOrCreateWorkspaceContext(context.Background(), name)
+}
+
+func (t *terraform) SelectOrCreateWorkspaceContext(ctx context.Context, name string) error {
+	if workspace, ok := t.workspaceOverride(); ok {
+		return fmt.Errorf("cannot select workspace '%s': the workspace is overridden by %s='%s'", name, workspaceEnv, workspace)
+	}
+	workspaces, err := t.WorkspaceListContext(ctx)
+	if err != nil {
+		return err
+	}
+	for _, workspace := range workspaces {
+		if workspace == name {
+			return t.WorkspaceSelectContext(ctx, name)
+		}
+	}
+	return t.WorkspaceNewContext(ctx, name)
+}
+
+// workspaceOverride returns the workspace set by the TF_WORKSPACE environment variable of terraform
+func (t *terraform) workspaceOverride() (string, bool) {
+	if workspace, ok := t.env[workspaceEnv]; ok {
+		return workspace, workspace != ""
+	}
+	workspace := os.Getenv(workspaceEnv)
+	return workspace, workspace != ""
+}
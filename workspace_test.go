@@ -0,0 +1,72 @@
+package tfcli
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func workspaceTerraform(t *testing.T) (Terraform, string) {
+	return recordingTerraform(t, `
+case "$1 $2" in
+"workspace list")
+	echo "  default"
+	echo "* dev"
+	echo "  prod"
+	echo
+	;;
+"workspace show")
+	echo "dev"
+	;;
+esac
+`)
+}
+
+func TestWorkspaces(t *testing.T) {
+	tf, dir := workspaceTerraform(t)
+
+	workspaces, err := tf.WorkspaceList()
+	must(t, err)
+	assert.Equal(t, []string{"default", "dev", "prod"}, workspaces)
+
+	current, err := tf.WorkspaceShow()
+	must(t, err)
+	assert.Equal(t, "dev", current)
+
+	must(t, tf.WorkspaceNew("test"))
+	must(t, tf.WorkspaceSelect("test"))
+	must(t, tf.WorkspaceDelete("test", false))
+	must(t, tf.WorkspaceDelete("test", true))
+
+	assert.Equal(t, []string{
+		"workspace list",
+		"workspace show",
+		"workspace new -no-color test",
+		"workspace select -no-color test",
+		"workspace delete -no-color test",
+		"workspace delete -no-color -force test",
+	}, readCalls(t, dir))
+}
+
+func TestSelectOrCreateWorkspace(t *testing.T) {
+	tf, dir := workspaceTerraform(t)
+	must(t, tf.SelectOrCreateWorkspace("prod"))
+	must(t, tf.SelectOrCreateWorkspace("customer"))
+	assert.Equal(t, []string{
+		"workspace list",
+		"workspace select -no-color prod",
+		"workspace list",
+		"workspace new -no-color customer",
+	}, readCalls(t, dir))
+}
+
+func TestSelectOrCreateWorkspaceOverride(t *testing.T) {
+	tf, dir := workspaceTerraform(t)
+	tf.WithEnv(map[string]string{"TF_WORKSPACE": "prod"})
+	err := tf.SelectOrCreateWorkspace("customer")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "TF_WORKSPACE")
+	}
+	assert.NoFileExists(t, filepath.Join(dir, "calls.log"))
+}